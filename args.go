@@ -0,0 +1,139 @@
+package star
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// BuildArgs returns arguments for cmd which provide the values in vals.
+// vals is keyed by the names of parameters, as they appear in the Schema for cmd.
+// Flags are passed first, in order by name, followed by positional arguments in order.
+// A flag which takes no value is passed once for each of its values, and the values are ignored.
+// It is an error for a positional value to look like a flag, because it would be parsed as one.
+func BuildArgs(cmd Command, vals map[string][]string) ([]string, error) {
+	byName := make(map[string]Parameter)
+	for param, name := range makeParamNames(cmd.canonicalFlags(), cmd.Pos) {
+		byName[name] = param
+	}
+	byParam := make(map[Parameter][]string, len(vals))
+	for name, vs := range vals {
+		param, exists := byName[name]
+		if !exists {
+			return nil, fmt.Errorf("command does not have a parameter %q", name)
+		}
+		byParam[param] = vs
+	}
+	return buildArgs(cmd, byParam)
+}
+
+// buildArgs is BuildArgs with vals keyed by Parameter.
+func buildArgs(cmd Command, vals map[Parameter][]string) ([]string, error) {
+	names := makeParamNames(cmd.canonicalFlags(), cmd.Pos)
+	for param := range vals {
+		if _, exists := names[param]; !exists {
+			return nil, fmt.Errorf("command does not have parameter %T", param)
+		}
+	}
+
+	var args []string
+	flags := maps.Keys(makeParamNames(cmd.canonicalFlags(), nil))
+	slices.SortFunc(flags, func(a, b Parameter) int {
+		return strings.Compare(names[a], names[b])
+	})
+	for _, param := range flags {
+		if isPositional(cmd, param) {
+			continue
+		}
+		for _, v := range vals[param] {
+			if takesValue(param) {
+				args = append(args, flagName(names[param]), v)
+			} else {
+				args = append(args, flagName(names[param]))
+			}
+		}
+	}
+	var missing string
+	for i, pos := range cmd.Pos {
+		name := positionalName(pos, i)
+		if len(vals[pos]) == 0 {
+			if missing == "" {
+				missing = name
+			}
+			continue
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("positional parameter %q must be provided before %q", missing, name)
+		}
+		for _, v := range vals[pos] {
+			if isFlag(v) || isShortFlag(cmd.Flags, v) {
+				return nil, fmt.Errorf("value %q for positional parameter %q would be parsed as a flag", v, name)
+			}
+		}
+		args = append(args, vals[pos]...)
+	}
+	return args, nil
+}
+
+func isPositional(cmd Command, p Parameter) bool {
+	for _, pos := range cmd.Pos {
+		if pos == p {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONStrings converts a JSON value into a list of argument strings, for use with BuildArgs.
+// Strings and numbers produce a single string, and arrays produce one string per element.
+// true produces a single empty string, which sets a flag that takes no value, and false and null produce nothing.
+func JSONStrings(raw json.RawMessage) ([]string, error) {
+	var x any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if x {
+			return []string{""}, nil
+		}
+		return nil, nil
+	case []any:
+		var ret []string
+		for _, elem := range x {
+			s, err := scalarString(elem)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	default:
+		s, err := scalarString(x)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+func scalarString(x any) (string, error) {
+	switch x := x.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return fmt.Sprint(x), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", x)
+	}
+}
@@ -0,0 +1,260 @@
+package star
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+)
+
+// BatchFraming is how the output of each line in a batch is presented.
+type BatchFraming int
+
+const (
+	// FramePrefix writes each line of output prefixed with the line number of the invocation.
+	FramePrefix = BatchFraming(iota)
+	// FrameJSONL writes a JSON object for each invocation, with its output and exit status.
+	FrameJSONL
+)
+
+// BatchOptions configure RunBatch
+type BatchOptions struct {
+	// ContinueOnError runs the remaining lines after a line fails.
+	// Otherwise, the batch is stopped and any lines in progress are cancelled.
+	ContinueOnError bool
+	// Parallel is the maximum number of lines to run at once.
+	// If Parallel is < 1, then lines are run one at a time.
+	Parallel int
+	Framing  BatchFraming
+}
+
+// BatchResult is the result of running a single line in a batch.
+// It is written as a JSON object for FrameJSONL.
+type BatchResult struct {
+	// Line is the line number of the invocation in the input, starting from 1.
+	Line   int      `json:"line"`
+	Args   []string `json:"args"`
+	Stdout string   `json:"stdout"`
+	Stderr string   `json:"stderr"`
+	// ExitCode is 0 if the command succeeded.
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunBatch reads lines of arguments from r, splits them with SplitArgs, and runs each with cmd.
+// Blank lines, and lines starting with # are skipped.
+// Each line is run with its own context, and has its own BatchResult.
+// Output is written to stdout in order by line, regardless of the order the lines finish in.
+// RunBatch returns an error if any line failed.
+func RunBatch(ctx context.Context, cmd Command, env map[string]string, calledAs string, r io.Reader, stdout io.Writer, opts BatchOptions) error {
+	sem := make(chan struct{}, max(opts.Parallel, 1))
+	// stopped is closed when a line fails, and ContinueOnError is not set.
+	stopped := make(chan struct{})
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results []*BatchResult
+		cancels []context.CancelFunc
+		done    []bool
+		nextOut int
+		failed  bool
+		stopErr error
+		outErr  error
+	)
+	// stop cancels the lines in progress, and prevents any more from starting, because the line at index i failed.
+	// It must be called with mu held.
+	stop := func(i int) {
+		if stopErr != nil {
+			return
+		}
+		stopErr = fmt.Errorf("cancelled because line %d failed", results[i].Line)
+		close(stopped)
+		for j, cancel := range cancels {
+			if j != i && cancel != nil {
+				cancel()
+			}
+		}
+	}
+	// finish marks result i as done, and writes all of the results which are ready, in order.
+	finish := func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[i] = true
+		cancels[i] = nil
+		if results[i].ExitCode != 0 && !opts.ContinueOnError {
+			stop(i)
+		}
+		for ; nextOut < len(results) && done[nextOut]; nextOut++ {
+			res := results[nextOut]
+			if res.ExitCode != 0 {
+				failed = true
+			}
+			if outErr == nil {
+				outErr = writeBatchResult(stdout, opts.Framing, res)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-stopped:
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		isStopped := stopErr != nil
+		mu.Unlock()
+		if isStopped || ctx.Err() != nil {
+			break
+		}
+		args, err := SplitArgs(line)
+		res := &BatchResult{Line: lineNum, Args: args}
+		lineCtx, cancel := context.WithCancel(ctx)
+		mu.Lock()
+		results = append(results, res)
+		cancels = append(cancels, cancel)
+		done = append(done, false)
+		idx := len(results) - 1
+		mu.Unlock()
+		if err != nil {
+			cancel()
+			res.ExitCode, res.Error = 1, err.Error()
+			<-sem
+			finish(idx)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer cancel()
+			var outBuf, errBuf bytes.Buffer
+			err := Run(lineCtx, cmd, maps.Clone(env), calledAs, args, strings.NewReader(""), &outBuf, &errBuf)
+			res.Stdout, res.Stderr = outBuf.String(), errBuf.String()
+			if err != nil {
+				mu.Lock()
+				if stopErr != nil && lineCtx.Err() != nil {
+					// the line was cancelled because another line failed.
+					err = stopErr
+				}
+				mu.Unlock()
+				res.ExitCode, res.Error = ExitCode(err), err.Error()
+			}
+			finish(idx)
+		}()
+	}
+	wg.Wait()
+	if outErr != nil {
+		return outErr
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("batch: one or more lines failed")
+	}
+	return nil
+}
+
+func writeBatchResult(w io.Writer, framing BatchFraming, res *BatchResult) error {
+	switch framing {
+	case FrameJSONL:
+		return json.NewEncoder(w).Encode(res)
+	default:
+		prefix := fmt.Sprintf("%d: ", res.Line)
+		for _, line := range splitLines(res.Stdout) {
+			if _, err := fmt.Fprintf(w, "%s%s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+		for _, line := range splitLines(res.Stderr) {
+			if _, err := fmt.Fprintf(w, "%s%s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+		if res.Error != "" {
+			if _, err := fmt.Fprintf(w, "%serror (exit %d): %s\n", prefix, res.ExitCode, res.Error); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func splitLines(x string) []string {
+	if x == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(x, "\n"), "\n")
+}
+
+// WithBatch returns a copy of the directory command dir, with a batch child
+// which runs lines of arguments with dir using RunBatch.
+// The lines are run with the name dir was called as, rather than "batch".
+// The lines are read from the file passed positionally, or from StdIn if it is "-" or omitted.
+func WithBatch(dir Command) Command {
+	file := &Optional[string]{
+		PosName:  "file",
+		Parse:    ParseString,
+		ShortDoc: "the file to read lines of arguments from, - for stdin",
+	}
+	keepGoing := &Boolean{ShortDoc: "keep running lines after one fails"}
+	parallel := &Optional[int]{
+		Parse:    strconv.Atoi,
+		Default:  Ptr(1),
+		Checks:   []Check[int]{InRange(1, 1024)},
+		ShortDoc: "the number of lines to run at once",
+	}
+	framing := &Optional[BatchFraming]{
+		Choices:  Choices[BatchFraming]{"prefix": FramePrefix, "jsonl": FrameJSONL},
+		Default:  Ptr(FramePrefix),
+		ShortDoc: "how the output of each line is presented",
+	}
+	dir = recordCalledAs(dir)
+	dir.Children = maps.Clone(dir.Children)
+	dir.Children["batch"] = Command{
+		Metadata: Metadata{Short: "runs many invocations, one per line of a file"},
+		Pos:      []Positional{file},
+		Flags: map[string]Flag{
+			"continue-on-error": keepGoing,
+			"parallel":          parallel,
+			"output":            framing,
+		},
+		F: func(c Context) error {
+			r := c.StdIn
+			if p, ok := file.LoadOpt(c); ok && p != "-" {
+				f, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			par, _ := parallel.LoadOpt(c)
+			fr, _ := framing.LoadOpt(c)
+			return RunBatch(c.Context, dir, c.Env, parentCalledAs(c), r, c.StdOut, BatchOptions{
+				ContinueOnError: keepGoing.Load(c),
+				Parallel:        par,
+				Framing:         fr,
+			})
+		},
+		helper: true,
+	}
+	return dir
+}
@@ -0,0 +1,169 @@
+package star
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Check is a constraint on a single value of a parameter.
+// Checks are applied after the value has been parsed.
+type Check[T any] struct {
+	// Doc describes the constraint in help text.
+	Doc string
+	F   func(T) error
+}
+
+// InRange returns a Check which requires values to be within [lo, hi].
+func InRange[T cmp.Ordered](lo, hi T) Check[T] {
+	return Check[T]{
+		Doc: fmt.Sprintf("in [%v, %v]", lo, hi),
+		F: func(x T) error {
+			if x < lo || x > hi {
+				return fmt.Errorf("%v is not in [%v, %v]", x, lo, hi)
+			}
+			return nil
+		},
+	}
+}
+
+// MatchesRegexp returns a Check which requires values to match re.
+func MatchesRegexp(re *regexp.Regexp) Check[string] {
+	return Check[string]{
+		Doc: fmt.Sprintf("matches %s", re),
+		F: func(x string) error {
+			if !re.MatchString(x) {
+				return fmt.Errorf("%q does not match %s", x, re)
+			}
+			return nil
+		},
+	}
+}
+
+// FileExists returns a Check which requires values to be paths to existing files.
+func FileExists() Check[string] {
+	return Check[string]{
+		Doc: "file must exist",
+		F: func(x string) error {
+			_, err := os.Stat(x)
+			return err
+		},
+	}
+}
+
+func applyChecks[T any](checks []Check[T], x any, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range checks {
+		if err := check.F(x.(T)); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func checkDocs[T any](checks []Check[T]) (ret []string) {
+	for _, check := range checks {
+		if check.Doc != "" {
+			ret = append(ret, check.Doc)
+		}
+	}
+	return ret
+}
+
+type ruleKind int
+
+const (
+	ruleMutuallyExclusive = ruleKind(iota)
+	ruleRequires
+	ruleAtLeastOneOf
+)
+
+// Rule is a constraint on which parameters of a Command can be provided together.
+// Rules are checked before the Command's function is called.
+// A parameter only counts as provided if it has a value which is not a default.
+type Rule struct {
+	kind   ruleKind
+	params []Parameter
+}
+
+// MutuallyExclusive returns a Rule which allows at most one of params to be provided.
+func MutuallyExclusive(params ...Parameter) Rule {
+	return Rule{kind: ruleMutuallyExclusive, params: params}
+}
+
+// Requires returns a Rule which requires all of deps to be provided if p is provided.
+func Requires(p Parameter, deps ...Parameter) Rule {
+	return Rule{kind: ruleRequires, params: append([]Parameter{p}, deps...)}
+}
+
+// AtLeastOneOf returns a Rule which requires at least one of params to be provided.
+func AtLeastOneOf(params ...Parameter) Rule {
+	return Rule{kind: ruleAtLeastOneOf, params: params}
+}
+
+func (r Rule) check(vals map[Parameter][]any, names map[Parameter]string) error {
+	var provided []Parameter
+	for _, p := range r.params {
+		if len(vals[p]) > 0 {
+			provided = append(provided, p)
+		}
+	}
+	switch r.kind {
+	case ruleMutuallyExclusive:
+		if len(provided) > 1 {
+			return fmt.Errorf("parameters %s cannot be used together", joinNames(provided, names))
+		}
+	case ruleRequires:
+		if len(vals[r.params[0]]) == 0 {
+			return nil
+		}
+		for _, dep := range r.params[1:] {
+			if len(vals[dep]) == 0 {
+				return fmt.Errorf("parameter %s requires %s", names[r.params[0]], names[dep])
+			}
+		}
+	case ruleAtLeastOneOf:
+		if len(provided) == 0 {
+			return fmt.Errorf("at least one of %s must be provided", joinNames(r.params, names))
+		}
+	}
+	return nil
+}
+
+func (r Rule) doc(names map[Parameter]string) string {
+	switch r.kind {
+	case ruleMutuallyExclusive:
+		return fmt.Sprintf("at most one of %s", joinNames(r.params, names))
+	case ruleRequires:
+		return fmt.Sprintf("%s requires %s", names[r.params[0]], joinNames(r.params[1:], names))
+	case ruleAtLeastOneOf:
+		return fmt.Sprintf("at least one of %s", joinNames(r.params, names))
+	default:
+		return ""
+	}
+}
+
+func joinNames(params []Parameter, names map[Parameter]string) string {
+	strs := make([]string, len(params))
+	for i, p := range params {
+		strs[i] = names[p]
+	}
+	return strings.Join(strs, ", ")
+}
+
+// displayNames returns the names of parameters as they would be written by a user.
+// Flags are prefixed once, even if they have several names, and positionals are not prefixed.
+func displayNames(flags map[string]Flag, pos []Positional) map[Parameter]string {
+	ret := makeParamNames(flags, nil)
+	for param, name := range ret {
+		ret[param] = flagName(name)
+	}
+	for i, param := range pos {
+		ret[param] = positionalName(param, i)
+	}
+	return ret
+}
@@ -0,0 +1,274 @@
+package star
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// List is a parameter whose values are separated by Sep within a single argument.
+// e.g. --tags a,b,c
+// Values can be quoted to include Sep e.g. --tags 'a,"b,c"'
+// A List can also be passed multiple times, the values from each argument are concatenated.
+type List[T any] struct {
+	// PosName is only used for positional parameters in doc/error messages.
+	PosName string
+
+	Parse Parser[T]
+	// Format, if set, is the inverse of Parse.  See FormatArgs.
+	Format Formatter[T]
+	// Sep separates values within an argument.
+	// If Sep is 0, then ',' is used.
+	Sep rune
+
+	// Env is the name of an environment variable which provides a value if none is passed as an argument.
+	Env string
+	// ConfigKey is the key in the config file which provides a value if none is passed as an argument or in the environment.
+	ConfigKey string
+	// Default is the list used if no other value is provided.
+	Default []T
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
+
+	ShortDoc string
+}
+
+// Load returns all of the values for the list, from all arguments, in order.
+func (p *List[T]) Load(c Context) []T {
+	panicIfNotHas(p, c)
+	var ret []T
+	for _, v := range c.Values[p] {
+		ret = append(ret, v.([]T)...)
+	}
+	return ret
+}
+
+func (p *List[T]) ParseArg(x string) (any, error) {
+	fields, err := splitList(x, p.sep())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]T, 0, len(fields))
+	for _, field := range fields {
+		v, err := p.Parse(field)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (p *List[T]) FormatArg(v any) (string, error) {
+	xs, ok := v.([]T)
+	if !ok {
+		return "", fmt.Errorf("value %v is a %T, not a %s", v, v, typeName[[]T]())
+	}
+	fields := make([]string, len(xs))
+	for i, x := range xs {
+		field, err := formatWith(p.Format, nil, x)
+		if err != nil {
+			return "", err
+		}
+		fields[i] = field
+	}
+	return joinList(fields, p.sep())
+}
+
+func (p *List[T]) sep() rune {
+	if p.Sep == 0 {
+		return ','
+	}
+	return p.Sep
+}
+
+func (p *List[T]) UsagePositional(name string) string {
+	return fmt.Sprintf("[%s%c...]", name, p.sep())
+}
+
+func (p *List[T]) UsageFlag(name string) string {
+	return fmt.Sprintf("(list, separated by %q)", p.sep())
+}
+
+func (p *List[T]) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		PosName:    p.PosName,
+		MinCount:   0,
+		MaxCount:   math.MaxInt,
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   listDefaults(p.Default),
+		Type:       typeName[[]T](),
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
+}
+
+func listDefaults[T any](xs []T) []any {
+	if len(xs) == 0 {
+		return nil
+	}
+	return []any{xs}
+}
+
+// splitList splits x on sep, allowing fields to be quoted.
+func splitList(x string, sep rune) ([]string, error) {
+	if x == "" {
+		return nil, nil
+	}
+	r := csv.NewReader(strings.NewReader(x))
+	r.Comma = sep
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid list %q: %w", x, err)
+	}
+	// a newline outside of quotes would start another record.
+	if _, err := r.Read(); err != io.EOF {
+		return nil, fmt.Errorf("invalid list %q: newlines must be quoted", x)
+	}
+	return fields, nil
+}
+
+// Map is a parameter which is passed as key, value pairs separated by Sep.
+// e.g. --label env=prod --label team=x
+// Each key can only be provided once.
+type Map[K comparable, V any] struct {
+	// PosName is only used for positional parameters in doc/error messages.
+	PosName string
+
+	ParseKey   Parser[K]
+	ParseValue Parser[V]
+	// FormatKey and FormatValue, if set, are the inverses of ParseKey and ParseValue.  See FormatArgs.
+	FormatKey   Formatter[K]
+	FormatValue Formatter[V]
+	// Sep separates the key from the value.
+	// If Sep is 0, then '=' is used.
+	Sep rune
+
+	// Env is the name of an environment variable which provides a value if none is passed as an argument.
+	Env string
+	// ConfigKey is the key in the config file which provides a value if none is passed as an argument or in the environment.
+	ConfigKey string
+	// Default is the entries used if no other value is provided.
+	Default map[K]V
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
+
+	ShortDoc string
+}
+
+type mapEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Load returns all of the entries provided for the parameter.
+func (p *Map[K, V]) Load(c Context) map[K]V {
+	panicIfNotHas(p, c)
+	ret := make(map[K]V)
+	for _, v := range c.Values[p] {
+		ent := v.(mapEntry[K, V])
+		ret[ent.Key] = ent.Value
+	}
+	return ret
+}
+
+func (p *Map[K, V]) ParseArg(x string) (any, error) {
+	k, v, ok := strings.Cut(x, string(p.sep()))
+	if !ok {
+		return nil, fmt.Errorf("%q is not of the form key%cvalue", x, p.sep())
+	}
+	key, err := p.ParseKey(k)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	val, err := p.ParseValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for key %q: %w", k, err)
+	}
+	return mapEntry[K, V]{Key: key, Value: val}, nil
+}
+
+func (p *Map[K, V]) FormatArg(v any) (string, error) {
+	ent, ok := v.(mapEntry[K, V])
+	if !ok {
+		return "", fmt.Errorf("value %v is a %T, not an entry in a %s", v, v, typeName[map[K]V]())
+	}
+	k, err := formatWith(p.FormatKey, nil, ent.Key)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsRune(k, p.sep()) {
+		return "", fmt.Errorf("key %q contains separator %q", k, p.sep())
+	}
+	val, err := formatWith(p.FormatValue, nil, ent.Value)
+	if err != nil {
+		return "", err
+	}
+	return k + string(p.sep()) + val, nil
+}
+
+func (p *Map[K, V]) CheckValues(vals []any) error {
+	seen := make(map[K]struct{}, len(vals))
+	for _, v := range vals {
+		ent := v.(mapEntry[K, V])
+		if _, exists := seen[ent.Key]; exists {
+			return fmt.Errorf("duplicate key %v", ent.Key)
+		}
+		seen[ent.Key] = struct{}{}
+	}
+	return nil
+}
+
+func (p *Map[K, V]) sep() rune {
+	if p.Sep == 0 {
+		return '='
+	}
+	return p.Sep
+}
+
+func (p *Map[K, V]) UsagePositional(name string) string {
+	return fmt.Sprintf("[%s:key%cvalue ...]", name, p.sep())
+}
+
+func (p *Map[K, V]) UsageFlag(name string) string {
+	return fmt.Sprintf("(key%cvalue, repeated)", p.sep())
+}
+
+func (p *Map[K, V]) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		PosName:    p.PosName,
+		MinCount:   0,
+		MaxCount:   math.MaxInt,
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   p.defaults(),
+		Type:       typeName[map[K]V](),
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
+}
+
+// defaults returns an entry for each key in Default, in order by key.
+func (p *Map[K, V]) defaults() []any {
+	var ret []any
+	for k, v := range p.Default {
+		ret = append(ret, mapEntry[K, V]{Key: k, Value: v})
+	}
+	slices.SortFunc(ret, func(a, b any) int {
+		return strings.Compare(fmt.Sprint(a.(mapEntry[K, V]).Key), fmt.Sprint(b.(mapEntry[K, V]).Key))
+	})
+	return ret
+}
@@ -2,20 +2,96 @@ package star
 
 import (
 	"fmt"
+	"io"
+	"slices"
 	"strings"
+
+	"golang.org/x/exp/maps"
 )
 
 type Metadata struct {
 	Short string
-	// Tags for grouping by category
+	// Tags for grouping by category.
+	// NewTaggedDir lists its children in a group for each tag.
 	Tags []string
+	// Hidden commands can be run, but are not listed by their parent.
+	Hidden bool
+	// Deprecated commands can be run, but print a warning when they are.
+	Deprecated *Deprecation
+}
+
+// Deprecation describes why something is deprecated, and what to use instead.
+type Deprecation struct {
+	// Message is shown in warnings and help text.
+	Message string
+	// Replacement is what should be used instead, if anything.
+	Replacement string
+}
+
+// describe returns a sentence saying that what is deprecated.
+func (d Deprecation) describe(what string) string {
+	return what + " is " + d.note()
+}
+
+// note returns the deprecation as it appears in help text.
+func (d Deprecation) note() string {
+	ret := "deprecated"
+	if d.Replacement != "" {
+		ret += ", use " + d.Replacement + " instead"
+	}
+	if d.Message != "" {
+		ret += ": " + d.Message
+	}
+	return ret
 }
 
 type Command struct {
 	Metadata
 	Flags map[string]Flag
 	Pos   []Positional
+	// Rules are constraints on which parameters can be provided together.
+	Rules []Rule
 	F     func(c Context) error
+
+	// Children are the commands that a directory command dispatches to, by name.
+	// It is nil for commands which are not directories.
+	Children map[string]Command
+	// Groups are used to list the Children of a directory command in sections.
+	Groups []Group
+	// DeprecatedFlags are names in Flags which still work, but print a warning when they are used.
+	// They are usually aliases for the same Flag as a newer name.
+	DeprecatedFlags map[string]Deprecation
+
+	// helper is set on the children added by WithShell, WithBatch, WithVersion and WithInstallLinks.
+	// install-links does not create links for them.
+	helper bool
+}
+
+// canonicalFlags returns the Flags, without deprecated names for parameters which have another name.
+// It is used to choose how parameters are named.
+func (c Command) canonicalFlags() map[string]Flag {
+	if len(c.DeprecatedFlags) == 0 {
+		return c.Flags
+	}
+	current := make(map[Parameter]bool)
+	for k, flag := range c.Flags {
+		if _, deprecated := c.DeprecatedFlags[k]; !deprecated {
+			current[flag] = true
+		}
+	}
+	ret := make(map[string]Flag, len(c.Flags))
+	for k, flag := range c.Flags {
+		if _, deprecated := c.DeprecatedFlags[k]; deprecated && current[flag] {
+			continue
+		}
+		ret[k] = flag
+	}
+	return ret
+}
+
+// IsDir returns true if the command dispatches to child commands.
+func (c Command) IsDir() bool {
+	return c.Children != nil
 }
 
 func (c Command) HasParam(x Parameter) bool {
@@ -34,37 +110,109 @@ func (c Command) HasParam(x Parameter) bool {
 
 func (c Command) Doc(calledAs string) string {
 	sb := &strings.Builder{}
-	fmt.Fprintf(sb, "%s ", calledAs)
+	sb.WriteString(calledAs)
 	for i, pos := range c.Pos {
-		sb.WriteString(pos.usagePositional(positionalName(pos, i)))
+		if pos.ParamInfo().Hidden {
+			continue
+		}
+		sb.WriteString(" ")
+		sb.WriteString(pos.UsagePositional(positionalName(pos, i)))
+	}
+
+	if c.Deprecated != nil {
+		sb.WriteString("\n\n")
+		sb.WriteString(c.Deprecated.describe("This command"))
 	}
 
 	sb.WriteString("\n\nPOSITIONAL:\n")
-	if len(c.Pos) == 0 {
-		sb.WriteString("  (this command does not accept any positional parameters)\n")
-	} else {
-		for i, pos := range c.Pos {
-			fmt.Fprintf(sb, "  %-10s\t%s\n", positionalName(pos, i), pos.getShortDoc())
+	var anyVisible bool
+	for i, pos := range c.Pos {
+		if pos.ParamInfo().Hidden {
+			continue
 		}
+		anyVisible = true
+		fmt.Fprintf(sb, "  %-10s\t%s\n", positionalName(pos, i), paramDoc(pos))
+	}
+	if !anyVisible {
+		sb.WriteString("  (this command does not accept any positional parameters)\n")
 	}
 
 	sb.WriteString("\nFLAGS:\n")
 	if len(c.Flags) == 0 {
 		sb.WriteString("  (this command does not accept any parameters as flags)\n")
 	} else {
-		for key, flag := range c.Flags {
-			fmt.Fprintf(sb, "  --%-20s %s\n", key, flag.getShortDoc())
+		writeFlags(sb, c)
+	}
+	if len(c.Rules) > 0 {
+		names := displayNames(c.canonicalFlags(), c.Pos)
+		sb.WriteString("\nRULES:\n")
+		for _, rule := range c.Rules {
+			fmt.Fprintf(sb, "  %s\n", rule.doc(names))
 		}
 	}
 	sb.WriteString("\n")
 	return sb.String()
 }
 
-func positionalName(pos Positional, i int) string {
-	if x, ok := pos.(posNamer); ok {
-		if name := x.getPosName(); name != "" {
-			return name
+// writeFlags writes a line to w for each of the flags of c which are not hidden.
+func writeFlags(w io.Writer, c Command) {
+	names := displayNames(c.canonicalFlags(), c.Pos)
+	keys := maps.Keys(c.Flags)
+	slices.Sort(keys)
+	for _, key := range keys {
+		flag := c.Flags[key]
+		if flag.ParamInfo().Hidden {
+			continue
+		}
+		if d, deprecated := c.DeprecatedFlags[key]; deprecated {
+			if d.Replacement == "" && names[flag] != flagName(key) {
+				d.Replacement = names[flag]
+			}
+			fmt.Fprintf(w, "  %-22s (%s)\n", flagName(key), d.note())
+			continue
 		}
+		fmt.Fprintf(w, "  %-22s %s\n", flagName(key), strings.TrimSpace(paramDoc(flag)+" "+flag.UsageFlag(key)))
+	}
+}
+
+// paramDoc returns the short doc for a parameter, followed by any constraints on its values.
+func paramDoc(p Parameter) string {
+	info := p.ParamInfo()
+	var notes []string
+	if len(info.Choices) > 0 {
+		notes = append(notes, "one of: "+strings.Join(info.Choices, ", "))
+	}
+	notes = append(notes, info.Notes...)
+	if info.Env != "" {
+		notes = append(notes, "env: "+info.Env)
+	}
+	if info.ConfigKey != "" {
+		notes = append(notes, "config: "+info.ConfigKey)
+	}
+	if len(info.Defaults) > 0 && !info.Secret {
+		notes = append(notes, "default: "+strings.Join(formatDefaults(p, info.Defaults), ", "))
+	}
+	if info.Deprecated != nil {
+		notes = append([]string{info.Deprecated.note()}, notes...)
+	}
+	doc := info.ShortDoc
+	if len(notes) > 0 {
+		doc = strings.TrimSpace(doc + " (" + strings.Join(notes, "; ") + ")")
+	}
+	return doc
+}
+
+// flagName returns the flag as it would be written on the command line.
+func flagName(key string) string {
+	if len(key) == 1 {
+		return shortFlagPrefix + key
+	}
+	return flagPrefix + key
+}
+
+func positionalName(pos Positional, i int) string {
+	if name := pos.ParamInfo().PosName; name != "" {
+		return name
 	}
 	return fmt.Sprintf("arg%d", i+1)
 }
@@ -0,0 +1,105 @@
+package star
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// Complete returns candidates for the last element of args, which may be empty or partially typed.
+// The elements before it are the arguments already passed to cmd.
+func Complete(cmd Command, args []string) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	prev, partial := args[:len(args)-1], pickLast(args)
+	if cmd.IsDir() {
+		for i := 0; i < len(prev); i++ {
+			arg := prev[i]
+			if strings.HasPrefix(arg, shortFlagPrefix) {
+				if flag, exists := cmd.Flags[strings.TrimLeft(arg, shortFlagPrefix)]; exists && takesValue(flag) && !hasInlineValue(arg) {
+					i++
+				}
+				continue
+			}
+			child, ok := cmd.Children[arg]
+			if !ok {
+				return nil
+			}
+			return Complete(child, args[i+1:])
+		}
+	}
+
+	var cands []string
+	if len(prev) > 0 {
+		if k, yes := strings.CutPrefix(pickLast(prev), flagPrefix); yes {
+			if flag, exists := cmd.Flags[k]; exists && takesValue(flag) {
+				return filterPrefix(flag.ParamInfo().Choices, partial)
+			}
+		}
+	}
+	if k, v, hasValue := strings.Cut(strings.TrimPrefix(partial, flagPrefix), "="); hasValue && isFlag(partial) {
+		// complete the value of --flag=value, keeping the flag in each candidate.
+		if flag, exists := cmd.Flags[k]; exists && takesValue(flag) {
+			for _, choice := range filterPrefix(flag.ParamInfo().Choices, v) {
+				cands = append(cands, flagPrefix+k+"="+choice)
+			}
+		}
+		return cands
+	}
+	if strings.HasPrefix(partial, shortFlagPrefix) {
+		for k, flag := range cmd.Flags {
+			if _, deprecated := cmd.DeprecatedFlags[k]; deprecated || flag.ParamInfo().Hidden {
+				continue
+			}
+			cands = append(cands, flagName(k))
+		}
+		slices.Sort(cands)
+		return filterPrefix(cands, partial)
+	}
+	if cmd.IsDir() {
+		return filterPrefix(visibleChildren(cmd.Children), partial)
+	}
+	if pos := nextPositional(cmd, prev); pos != nil {
+		cands = pos.ParamInfo().Choices
+	}
+	return filterPrefix(cands, partial)
+}
+
+// nextPositional returns the positional parameter which would receive the next argument after args.
+func nextPositional(cmd Command, args []string) Positional {
+	var n int
+	for i := 0; i < len(args); i++ {
+		if k, yes := strings.CutPrefix(args[i], flagPrefix); yes {
+			if flag, exists := cmd.Flags[k]; exists && takesValue(flag) && !hasInlineValue(args[i]) {
+				i++
+			}
+			continue
+		}
+		n++
+	}
+	for _, pos := range cmd.Pos {
+		maxCount := pos.ParamInfo().MaxCount
+		if n < maxCount {
+			return pos
+		}
+		n -= maxCount
+	}
+	return nil
+}
+
+func filterPrefix(xs []string, prefix string) (ret []string) {
+	for _, x := range xs {
+		if strings.HasPrefix(x, prefix) {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,362 @@
+package star
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a configuration file which provides values for parameters.
+// The top level of the file provides values for the root command,
+// and nested sections provide values for child commands of NewDir and NewGroupedDir.
+//
+// e.g. for a tree with a "serve" child command:
+//
+//	log-level: info
+//	serve:
+//	  port: 8080
+//
+// Values are looked up by each parameter's ConfigKey, and parsed with the parameter's Parser.
+// YAML, JSON, and TOML files are supported.  TOML tables are the nested sections.
+type Config struct {
+	path string
+	node *yaml.Node
+}
+
+// LoadConfig reads and parses the config file at p.
+func LoadConfig(p string) (*Config, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(p, data)
+}
+
+// ParseConfig parses data as a config file.
+// p is used to identify the file in error messages.
+func ParseConfig(p string, data []byte) (*Config, error) {
+	switch ext := filepath.Ext(p); ext {
+	case ".yaml", ".yml", ".json", "":
+	case ".toml":
+		return parseTOMLConfig(p, data)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q", ext)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		return &Config{path: p, node: &yaml.Node{Kind: yaml.MappingNode}}, nil
+	}
+	node := doc.Content[0]
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: config must be a mapping", p, node.Line)
+	}
+	return &Config{path: p, node: node}, nil
+}
+
+// parseTOMLConfig parses data as TOML, and converts it to the same tree of nodes as a YAML file.
+func parseTOMLConfig(p string, data []byte) (*Config, error) {
+	var m map[string]any
+	if _, err := toml.Decode(string(data), &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	node, err := tomlNode(m, tomlKeyLines(data), "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return &Config{path: p, node: node}, nil
+}
+
+// tomlNode converts a value decoded from TOML to a yaml.Node.
+// lines maps dotted key paths to the line they are defined on, and key is the path of x.
+func tomlNode(x any, lines map[string]int, key string, line int) (*yaml.Node, error) {
+	scalar := func(v string) (*yaml.Node, error) {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: v, Line: line}, nil
+	}
+	switch x := x.(type) {
+	case map[string]any:
+		node := &yaml.Node{Kind: yaml.MappingNode, Line: line}
+		for _, k := range sortedKeys(x) {
+			k2 := k
+			if key != "" {
+				k2 = key + "." + k
+			}
+			child, err := tomlNode(x[k], lines, k2, lines[k2])
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k, Line: lines[k2]}, child)
+		}
+		return node, nil
+	case []map[string]any:
+		// arrays of tables
+		elems := make([]any, len(x))
+		for i := range x {
+			elems[i] = x[i]
+		}
+		return tomlNode(elems, lines, key, line)
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Line: line}
+		for _, elem := range x {
+			child, err := tomlNode(elem, lines, key, line)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string:
+		return scalar(x)
+	case bool:
+		return scalar(strconv.FormatBool(x))
+	case int64:
+		return scalar(strconv.FormatInt(x, 10))
+	case float64:
+		return scalar(strconv.FormatFloat(x, 'g', -1, 64))
+	case time.Time:
+		return scalar(x.Format(time.RFC3339Nano))
+	case fmt.Stringer:
+		// local dates and times
+		return scalar(x.String())
+	default:
+		return nil, fmt.Errorf("unsupported value %v for %q", x, key)
+	}
+}
+
+// tomlKeyLines returns the line each key in a TOML document is first defined on, by dotted path.
+// It only understands table headers and key/value lines, which is enough to point at a value in error messages.
+func tomlKeyLines(data []byte) map[string]int {
+	ret := make(map[string]int)
+	var table []string
+	record := func(path []string, line int) {
+		k := strings.Join(path, ".")
+		if _, exists := ret[k]; !exists {
+			ret[k] = line
+		}
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "["):
+			name, _, _ := strings.Cut(strings.Trim(line, "[]"), "]")
+			table = splitTOMLKey(name)
+			record(table, i+1)
+		case strings.Contains(line, "=") && !strings.HasPrefix(line, "#"):
+			k, _, _ := strings.Cut(line, "=")
+			record(append(append([]string{}, table...), splitTOMLKey(k)...), i+1)
+		}
+	}
+	return ret
+}
+
+func splitTOMLKey(x string) []string {
+	parts := strings.Split(x, ".")
+	for i := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(parts[i]), `"'`)
+	}
+	return parts
+}
+
+// Path returns the path of the file the config was loaded from.
+func (c *Config) Path() string {
+	if c == nil {
+		return ""
+	}
+	return c.path
+}
+
+// Section returns the config for a child command called name.
+// Section returns nil if there is no such section.
+func (c *Config) Section(name string) *Config {
+	node := c.get(name)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	return &Config{path: c.path, node: node}
+}
+
+// configValue is a single value from a config file.
+type configValue struct {
+	Value string
+	Line  int
+}
+
+// lookup returns the values for key, a sequence of scalars produces multiple values.
+func (c *Config) lookup(key string) ([]configValue, error) {
+	node := c.get(key)
+	if node == nil {
+		return nil, nil
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []configValue{{Value: node.Value, Line: node.Line}}, nil
+	case yaml.SequenceNode:
+		var ret []configValue
+		for _, elem := range node.Content {
+			if elem.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s:%d: %q must be a list of scalars", c.path, elem.Line, key)
+			}
+			ret = append(ret, configValue{Value: elem.Value, Line: elem.Line})
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("%s:%d: %q must be a scalar or list of scalars", c.path, node.Line, key)
+	}
+}
+
+func (c *Config) get(key string) *yaml.Node {
+	if c == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(c.node.Content); i += 2 {
+		if c.node.Content[i].Value == key {
+			return c.node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+type configKey struct{}
+
+// WithConfig returns a context.Context which provides cfg to commands run with it.
+func WithConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, cfg)
+}
+
+// ConfigFrom returns the Config in ctx, or nil if there is none.
+func ConfigFrom(ctx context.Context) *Config {
+	cfg, _ := ctx.Value(configKey{}).(*Config)
+	return cfg
+}
+
+// withChildConfig scopes the config in ctx to the section for the child command.
+func withChildConfig(ctx context.Context, childName string) context.Context {
+	cfg := ConfigFrom(ctx)
+	if cfg == nil {
+		return ctx
+	}
+	return WithConfig(ctx, cfg.Section(childName))
+}
+
+// fillLayers provides values for parameters which were not set by the arguments.
+// The environment takes precedence over the config file, which takes precedence over defaults.
+func fillLayers(dst valueSet, params []Parameter, env map[string]string, cfg *Config) error {
+	for _, param := range params {
+		if len(dst.vals[param]) > 0 {
+			continue
+		}
+		info := dst.info(param)
+		if k := info.Env; k != "" {
+			if x, exists := env[k]; exists {
+				vs, err := parseLayer(param, x)
+				if err != nil {
+					return fmt.Errorf("invalid value in environment variable %s: %w", k, err)
+				}
+				for _, v := range vs {
+					dst.add(param, v, Source{Kind: SourceEnv, Name: k})
+				}
+				continue
+			}
+		}
+		if k := info.ConfigKey; k != "" {
+			cvs, err := cfg.lookup(k)
+			if err != nil {
+				return err
+			}
+			for _, cv := range cvs {
+				vs, err := parseLayer(param, cv.Value)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid value for %q: %w", cfg.path, cv.Line, k, err)
+				}
+				for _, v := range vs {
+					dst.add(param, v, Source{Kind: SourceConfig, Name: k, File: cfg.path, Line: cv.Line})
+				}
+			}
+			if len(cvs) > 0 {
+				continue
+			}
+		}
+		for _, v := range info.Defaults {
+			dst.add(param, v, Source{Kind: SourceDefault})
+		}
+	}
+	return nil
+}
+
+// layerParser is implemented by parameters which parse values from the environment or a config file
+// differently to arguments, like Counter, which takes a count.
+type layerParser interface {
+	parseLayer(x string) ([]any, error)
+}
+
+// parseLayer parses x from the environment or a config file into values for param.
+func parseLayer(param Parameter, x string) ([]any, error) {
+	if lp, ok := param.(layerParser); ok {
+		return lp.parseLayer(x)
+	}
+	v, err := param.ParseArg(x)
+	if err != nil {
+		return nil, err
+	}
+	return []any{v}, nil
+}
+
+// DefaultConfigPath returns the default path for an application's config file
+// e.g. $XDG_CONFIG_HOME/<appName>/config.yaml
+// The first of config.yaml, config.yml, config.toml and config.json which exists is used.
+// If none of them exist, the path to config.yaml is returned.
+func DefaultConfigPath(appName string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return findConfig(filepath.Join(dir, appName)), nil
+}
+
+// findConfig returns the path of the first config file in dir which exists, or dir/config.yaml.
+func findConfig(dir string) string {
+	for _, ext := range []string{".yaml", ".yml", ".toml", ".json"} {
+		p := filepath.Join(dir, "config"+ext)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// cutConfigFlag removes --config <path> from the args for cmd, and returns the path.
+// Only the arguments before the name of a child command are searched, so children can have their own --config flag.
+// If cmd has its own config flag, then args are returned unchanged.
+func cutConfigFlag(cmd Command, args []string) (string, []string) {
+	if _, exists := cmd.Flags["config"]; exists {
+		return "", args
+	}
+	for i := 0; i < len(args); i++ {
+		if !isFlag(args[i]) {
+			if cmd.IsDir() {
+				break
+			}
+			continue
+		}
+		if args[i] == flagPrefix+"config" && i+1 < len(args) {
+			return args[i+1], append(args[:i:i], args[i+2:]...)
+		}
+		if p, yes := strings.CutPrefix(args[i], flagPrefix+"config="); yes {
+			return p, append(args[:i:i], args[i+1:]...)
+		}
+		if flag, exists := cmd.Flags[strings.TrimPrefix(args[i], flagPrefix)]; exists && takesValue(flag) {
+			i++
+		}
+	}
+	return "", args
+}
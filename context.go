@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+
+	"golang.org/x/exp/maps"
 )
 
 // Context is the context in which a command is run.
@@ -12,7 +15,9 @@ import (
 type Context struct {
 	context.Context
 	// Values are parsed values keyed by Parameter identity.
-	Values   map[Parameter][]any
+	Values map[Parameter][]any
+	// Sources has an entry for each value in Values, saying where it came from.
+	Sources  map[Parameter][]Source
 	Env      map[string]string
 	StdIn    io.Reader
 	StdOut   io.Writer
@@ -34,60 +39,150 @@ func (c Context) Printf(format string, a ...any) {
 func Run(ctx context.Context, cmd Command, env map[string]string, calledAs string, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	mustHavePosNames(cmd)
 
+	var printConfig bool
+	if printConfigEnabled(ctx) && !cmd.IsDir() {
+		printConfig, args = cutPrintConfig(cmd.Flags, args)
+	}
 	params := make(map[Parameter][]any)
-	args, err := ParseFlags(params, cmd.Flags, args)
+	sources := make(map[Parameter][]Source)
+	vs := newValueSet(params, sources)
+	args, err := parseFlags(vs, cmd.Flags, args)
 	if err != nil {
 		fmt.Fprint(stderr, cmd.Doc(calledAs))
-		return err
+		return &UsageError{Kind: UsageFlag, Err: err}
 	}
-	args, err = ParsePos(params, cmd.Pos, args)
+	args, err = parsePos(vs, cmd.Pos, args)
 	if err != nil {
 		fmt.Fprint(stderr, cmd.Doc(calledAs))
-		return err
+		return &UsageError{Kind: UsagePositional, Err: err}
+	}
+	if err := fillLayers(vs, allParams(cmd.Flags, cmd.Pos), env, ConfigFrom(ctx)); err != nil {
+		fmt.Fprint(stderr, cmd.Doc(calledAs))
+		return &UsageError{Kind: UsageLayer, Err: err}
 	}
-	if err := checkParams(params, cmd.Flags, cmd.Pos); err != nil {
+	if err := checkParams(vs, cmd.canonicalFlags(), cmd.Pos, cmd.Rules); err != nil {
 		fmt.Fprint(stderr, cmd.Doc(calledAs))
 		return err
 	}
-	return cmd.F(Context{
+	warnDeprecated(stderr, cmd, calledAs, sources)
+	c := Context{
 		Context:  ctx,
 		Env:      env,
 		StdOut:   stdout,
 		StdIn:    stdin,
 		StdErr:   stderr,
 		Values:   params,
+		Sources:  sources,
 		Extra:    args,
 		CalledAs: calledAs,
 
 		self: &cmd,
-	})
+	}
+	if printConfig {
+		return WriteValues(stdout, c)
+	}
+	return cmd.F(c)
+}
+
+// warnDeprecated writes a warning to w for the command, if it is deprecated,
+// and for each deprecated parameter or flag name which was provided as an argument.
+func warnDeprecated(w io.Writer, cmd Command, calledAs string, sources map[Parameter][]Source) {
+	if cmd.Deprecated != nil && !cmd.IsDir() {
+		fmt.Fprintf(w, "warning: %s\n", cmd.Deprecated.describe("command "+calledAs))
+	}
+	names := displayNames(cmd.canonicalFlags(), cmd.Pos)
+	for _, param := range allParams(cmd.Flags, cmd.Pos) {
+		info := param.ParamInfo()
+		warned := make(map[string]bool)
+		for _, src := range sources[param] {
+			if src.Kind != SourceFlag && src.Kind != SourcePositional {
+				continue
+			}
+			if info.Deprecated != nil {
+				fmt.Fprintf(w, "warning: %s\n", info.Deprecated.describe("parameter "+names[param]))
+				break
+			}
+			d, deprecated := cmd.DeprecatedFlags[strings.TrimLeft(src.Name, "-")]
+			if !deprecated || src.Kind != SourceFlag || warned[src.Name] {
+				continue
+			}
+			if d.Replacement == "" && names[param] != src.Name {
+				d.Replacement = names[param]
+			}
+			fmt.Fprintf(w, "warning: %s\n", d.describe("flag "+src.Name))
+			warned[src.Name] = true
+		}
+	}
 }
 
 func mustHavePosNames(cmd Command) {
 	for i, pos := range cmd.Pos {
-		named, ok := pos.(posNamer)
-		if !ok || named.getPosName() == "" {
+		if pos.ParamInfo().PosName == "" {
 			panic(fmt.Sprintf("positional parameter at index %d must set non-empty PosName", i))
 		}
 	}
 }
 
-func checkParams(valueMap map[Parameter][]any, flags map[string]Flag, pos []Positional) error {
-	var allParams []Parameter
-	for _, x := range flags {
-		allParams = append(allParams, x)
-	}
+// allParams returns each of the parameters in flags and pos once.
+func allParams(flags map[string]Flag, pos []Positional) []Parameter {
+	var ret []Parameter
+	seen := make(map[Parameter]bool)
 	for _, x := range pos {
-		allParams = append(allParams, x)
+		if !seen[x] {
+			seen[x] = true
+			ret = append(ret, x)
+		}
 	}
+	keys := maps.Keys(flags)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if x := flags[k]; !seen[x] {
+			seen[x] = true
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
+
+func checkParams(vs valueSet, flags map[string]Flag, pos []Positional, rules []Rule) error {
+	valueMap := vs.vals
 	paramNames := makeParamNames(flags, pos)
-	for _, param := range allParams {
+	for _, param := range allParams(flags, pos) {
 		vals := valueMap[param]
-		if len(vals) < param.minCount() {
-			return fmt.Errorf("missing value for parameter %q", paramNames[param])
+		info := vs.info(param)
+		if len(vals) < info.MinCount {
+			if len(vals) == 0 {
+				return &UsageError{Kind: UsageMissing, Err: fmt.Errorf("missing value for parameter %q", paramNames[param])}
+			}
+			return &UsageError{Kind: UsageMissing, Err: fmt.Errorf("parameter %q requires at least %d values, got %d", paramNames[param], info.MinCount, len(vals))}
 		}
-		if len(vals) > param.maxCount() {
-			return fmt.Errorf("multiple values provided for parameter %q", paramNames[param])
+		if len(vals) > info.MaxCount {
+			if info.MaxCount == 1 {
+				return &UsageError{Kind: UsageTooMany, Err: fmt.Errorf("multiple values provided for parameter %q", paramNames[param])}
+			}
+			return &UsageError{Kind: UsageTooMany, Err: fmt.Errorf("parameter %q accepts at most %d values, got %d", paramNames[param], info.MaxCount, len(vals))}
+		}
+		if vc, ok := param.(ValuesChecker); ok {
+			if err := vc.CheckValues(vals); err != nil {
+				return &UsageError{Kind: UsageInvalid, Err: fmt.Errorf("invalid values for parameter %q: %w", paramNames[param], err)}
+			}
+		}
+	}
+	// defaults do not count as provided for the rules.
+	provided := make(map[Parameter][]any, len(valueMap))
+	for param, vals := range valueMap {
+		srcs := vs.srcs[param]
+		for i, v := range vals {
+			if i < len(srcs) && srcs[i].Kind == SourceDefault {
+				continue
+			}
+			provided[param] = append(provided[param], v)
+		}
+	}
+	displayNames := displayNames(flags, pos)
+	for _, rule := range rules {
+		if err := rule.check(provided, displayNames); err != nil {
+			return &UsageError{Kind: UsageRule, Err: err}
 		}
 	}
 	return nil
@@ -96,7 +191,10 @@ func checkParams(valueMap map[Parameter][]any, flags map[string]Flag, pos []Posi
 func makeParamNames(flags map[string]Flag, pos []Positional) map[Parameter]string {
 	ret := make(map[Parameter]string)
 	for flagName, param := range flags {
-		ret[param] = flagName
+		// prefer the longest name when a parameter has multiple flags.
+		if prev, exists := ret[param]; !exists || len(flagName) > len(prev) || (len(flagName) == len(prev) && flagName < prev) {
+			ret[param] = flagName
+		}
 	}
 	for i, param := range pos {
 		ret[param] = positionalName(param, i)
@@ -106,31 +204,62 @@ func makeParamNames(flags map[string]Flag, pos []Positional) map[Parameter]strin
 
 // ParsePos parses positional arguments
 func ParsePos(dst map[Parameter][]any, params []Positional, args []string) (rest []string, err error) {
+	return parsePos(newValueSet(dst, nil), params, args)
+}
+
+func parsePos(dst valueSet, params []Positional, args []string) (rest []string, err error) {
 	for i, param := range params {
 		name := positionalName(param, i)
-		for i := 0; i < param.maxCount() && len(args) > 0; i++ {
+		// leave enough arguments for the parameters after this one.
+		var reserved int
+		for _, next := range params[i+1:] {
+			reserved += dst.info(next).MinCount
+		}
+		info := dst.info(param)
+		for j := 0; j < info.MaxCount && len(args) > 0; j++ {
+			if j >= info.MinCount && countPos(args) <= reserved {
+				break
+			}
 			val, rest, err := parseOnePos(param, name, args)
 			if err != nil {
 				return nil, err
 			}
-			dst[param] = append(dst[param], val)
+			dst.add(param, val, Source{Kind: SourcePositional, Name: name})
 			args = rest
 		}
 	}
 	return args, nil
 }
 
+// countPos returns the number of args which could be parsed as positional arguments.
+func countPos(args []string) (n int) {
+	for i := 0; i < len(args); i++ {
+		if isFlag(args[i]) {
+			if !hasInlineValue(args[i]) {
+				i += 1
+			}
+			continue
+		}
+		n++
+	}
+	return n
+}
+
 func parseOnePos(p Parameter, name string, args []string) (vals any, rest []string, err error) {
 	for i := 0; i < len(args); i++ {
 		if isFlag(args[i]) {
-			// ignore flags
-			rest = append(rest, args[i])
+			// ignore flags, and the value which follows them.
+			if hasInlineValue(args[i]) {
+				rest = append(rest, args[i])
+				continue
+			}
+			rest = append(rest, args[i:min(i+2, len(args))]...)
 			i += 1
 			continue
 		}
-		val, err := p.parse(args[i])
+		val, err := p.ParseArg(args[i])
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("invalid value for positional argument %q: %w", name, err)
 		}
 		return val, append(rest, args[i+1:]...), nil
 	}
@@ -146,13 +275,61 @@ func isFlag(x string) bool {
 	return strings.HasPrefix(x, flagPrefix)
 }
 
-func isShortFlag(x string) bool {
-	return strings.HasPrefix(x, "-") && len(x) == 2
+// hasInlineValue returns true if x is a flag with its value in the same argument e.g. --output=json
+func hasInlineValue(x string) bool {
+	return isFlag(x) && strings.Contains(x, "=")
+}
+
+// isShortFlag returns true if x is a cluster of the single letter flags in flags e.g. -v or -vvv
+// Only the last flag in a cluster can take a value.
+// Other arguments starting with a single dash, such as negative numbers, are not short flags.
+func isShortFlag(flags map[string]Flag, x string) bool {
+	letters, yes := strings.CutPrefix(x, shortFlagPrefix)
+	if !yes || isFlag(x) || letters == "" {
+		return false
+	}
+	ks := strings.Split(letters, "")
+	for i, k := range ks {
+		param, exists := flags[k]
+		if !exists || (takesValue(param) && i < len(ks)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+func takesValue(p Parameter) bool {
+	return !p.ParamInfo().NoValue
+}
+
+func (vs valueSet) takesValue(p Parameter) bool {
+	return !vs.info(p).NoValue
 }
 
 // ParseFlags takes a slice of args, and parses paramaeters in the list of flags.
 // ParseFlags writes values to dst.
+// Flags with single letter names can also be passed with a single dash e.g. -v
+// and several of them can be clustered together e.g. -vvv or -xvf out.txt.
+// Only the last flag in a cluster can take a value.
 func ParseFlags(dst map[Parameter][]any, flags map[string]Flag, args []string) (rest []string, err error) {
+	rest, err = parseFlags(newValueSet(dst, nil), flags, args)
+	if err != nil {
+		return nil, err
+	}
+	for flagName, param := range flags {
+		info := param.ParamInfo()
+		if len(dst[param]) < info.MinCount {
+			return nil, fmt.Errorf("missing flag %q", flagName)
+		}
+		if len(dst[param]) > info.MaxCount {
+			return nil, fmt.Errorf("flag %q provided too many times (max %d)", flagName, info.MaxCount)
+		}
+	}
+	return rest, nil
+}
+
+// parseFlags is ParseFlags without checking that the number of values for each flag is valid.
+func parseFlags(dst valueSet, flags map[string]Flag, args []string) (rest []string, err error) {
 	flagIndex := make(map[string]Flag)
 	for k, flag := range flags {
 		flagIndex[k] = flag
@@ -161,30 +338,72 @@ func ParseFlags(dst map[Parameter][]any, flags map[string]Flag, args []string) (
 	for len(args) > 0 {
 		arg := args[0]
 		if k, yes := strings.CutPrefix(arg, flagPrefix); yes {
+			k, inline, hasInline := strings.Cut(k, "=")
 			if param, exists := flagIndex[k]; exists {
-				// TODO handle equals
-				if len(args) < 2 {
-					return nil, fmt.Errorf("arg named but not provided for %q", k)
+				if !dst.takesValue(param) {
+					if hasInline {
+						return nil, fmt.Errorf("flag %q does not take a value", k)
+					}
+					v, _ := param.ParseArg("")
+					dst.add(param, v, Source{Kind: SourceFlag, Name: flagName(k)})
+					args = args[1:]
+					continue
+				}
+				x := inline
+				if hasInline {
+					args = args[1:]
+				} else {
+					if len(args) < 2 {
+						return nil, fmt.Errorf("arg named but not provided for %q", k)
+					}
+					x = args[1]
+					args = args[2:]
 				}
-				v, err := param.parse(args[1])
+				v, err := param.ParseArg(x)
 				if err != nil {
-					return nil, err
+					return nil, fmt.Errorf("invalid value for flag %q: %w", k, err)
 				}
-				dst[param] = append(dst[param], v)
-				args = args[2:]
+				dst.add(param, v, Source{Kind: SourceFlag, Name: flagName(k)})
 				continue
 			}
 		}
+		if isShortFlag(flagIndex, arg) {
+			n, err := parseShortFlags(dst, flagIndex, args)
+			if err != nil {
+				return nil, err
+			}
+			args = args[n:]
+			continue
+		}
 		args = args[1:]
 		rest = append(rest, arg)
 	}
+	return rest, nil
+}
 
-	for flagName, param := range flagIndex {
-		if len(dst[param]) < param.minCount() {
-			return nil, fmt.Errorf("missing flag %q", flagName)
+// parseShortFlags parses a cluster of short flags from args[0], and a value for the last flag from args[1] if it needs one.
+// args[0] must be a cluster of flags, as determined by isShortFlag.
+// It returns the number of args consumed.
+func parseShortFlags(dst valueSet, flagIndex map[string]Flag, args []string) (int, error) {
+	letters := strings.Split(strings.TrimPrefix(args[0], shortFlagPrefix), "")
+	for _, k := range letters {
+		param := flagIndex[k]
+		if !dst.takesValue(param) {
+			v, _ := param.ParseArg("")
+			dst.add(param, v, Source{Kind: SourceFlag, Name: flagName(k)})
+			continue
+		}
+		if len(args) < 2 {
+			return 0, fmt.Errorf("arg named but not provided for %q", k)
 		}
+		v, err := param.ParseArg(args[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid value for flag %q: %w", k, err)
+		}
+		dst.add(param, v, Source{Kind: SourceFlag, Name: flagName(k)})
+		return 2, nil
 	}
-	return rest, nil
+	return 1, nil
 }
 
 // ParseString is a parser for strings, it is the identity function on strings, and never errors.
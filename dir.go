@@ -1,52 +1,43 @@
 package star
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 
 	"golang.org/x/exp/maps"
 )
 
+// NewDir returns a Command which dispatches to one of children, by name.
+// The name is taken from the first argument, and the rest of the arguments are passed to the child.
+// If no name is provided, the children are listed.
 func NewDir(md Metadata, children map[string]Command) Command {
 	return Command{
-		Pos:   []Positional{},
-		Flags: map[string]Flag{},
+		Metadata: md,
+		Pos:      []Positional{},
+		Flags:    map[string]Flag{},
+		Children: children,
 		F: func(ctx Context) error {
-			var childName string
-			var rest []string
-			for i := 0; i < len(ctx.Extra); i++ {
-				arg := ctx.Extra[i]
-				if isFlag(arg) {
-					i++
-					continue
-				}
-				childName, rest = arg, slices.Delete(ctx.Extra, i, i+1)
-				break
-			}
-
+			childName, rest := splitChildName(*ctx.self, ctx.Extra)
 			if childName == "" {
-				keys := maps.Keys(children)
-				slices.Sort(keys)
+				keys := visibleChildren(ctx.self.Children)
 				ctx.Printf("%s\n\n", filepath.Base(ctx.CalledAs))
 				ctx.Printf("%s\n\n", md.Short)
 				ctx.Printf("COMMANDS:\n")
 				fmtStr := "  %-" + strconv.Itoa(maxLen(keys)) + "s  %s\n"
 				for _, k := range keys {
-					child := children[k]
-					ctx.Printf(fmtStr, k, child.Metadata.Short)
+					ctx.Printf(fmtStr, k, listingShort(ctx.self.Children[k]))
 				}
 				ctx.Printf("\n")
+				printDirFlags(ctx)
 				return nil
 			}
-			child, ok := children[childName]
-			if !ok {
-				return fmt.Errorf("no command found for %q", childName)
-			}
-			return Run(ctx.Context, child, ctx.Env, childName, rest, ctx.StdIn, ctx.StdOut, ctx.StdErr)
+			return runChild(ctx, childName, rest)
 		},
-		Metadata: md,
 	}
 }
 
@@ -58,46 +49,246 @@ type Group struct {
 	Commands []string
 }
 
+// NewGroupedDir is like NewDir, except the children are listed in groups.
 func NewGroupedDir(md Metadata, groups []Group, children map[string]Command) Command {
 	return Command{
+		Metadata: md,
+		Children: children,
+		Groups:   groups,
 		F: func(ctx Context) error {
-			var childName string
-			var rest []string
-			for i := 0; i < len(ctx.Extra); i++ {
-				arg := ctx.Extra[i]
-				if isFlag(arg) {
-					i++
-					continue
-				}
-				childName, rest = arg, slices.Delete(ctx.Extra, i, i+1)
-				break
-			}
-
+			childName, rest := splitChildName(*ctx.self, ctx.Extra)
 			if childName == "" {
-				ctx.Printf("%s\n\n", filepath.Base(ctx.CalledAs))
-				ctx.Printf("%s\n\n", md.Short)
-				for _, g := range groups {
-					ctx.Printf("%s:\n", g.Title)
-					slices.Sort(g.Commands)
-					fmtStr := "  %-" + strconv.Itoa(maxLen(g.Commands)) + "s  %s\n"
-					for _, cmdName := range g.Commands {
-						child, ok := children[cmdName]
-						if !ok {
-							panic(fmt.Sprintf("No child command %q exists.  This is a bug.", cmdName))
-						}
-						ctx.Printf(fmtStr, cmdName, child.Metadata.Short)
-					}
-					ctx.Printf("\n")
-				}
+				printGroups(ctx, md, ctx.self.Groups)
 				return nil
 			} else {
-				child, ok := children[childName]
-				if !ok {
-					return fmt.Errorf("no command found for %q", childName)
+				return runChild(ctx, childName, rest)
+			}
+		},
+	}
+}
+
+// NewTaggedDir is like NewGroupedDir, except the groups are made from the Tags of the children.
+// There is a group for each tag, and a child with several tags is listed in each of their groups.
+// The groups for the tags in tagOrder are listed first, in that order, followed by the other tags in sorted order,
+// and then the children without any tags.
+// The groups are made each time the children are listed, so children added after NewTaggedDir are included.
+//
+// The listing can be filtered with --tag, which can be passed multiple times, to only list the groups for those tags.
+func NewTaggedDir(md Metadata, tagOrder []string, children map[string]Command) Command {
+	tagFilter := &Repeated[string]{
+		Parse:    ParseString,
+		ShortDoc: "only list the commands with this tag",
+	}
+	return Command{
+		Metadata: md,
+		Flags:    map[string]Flag{"tag": tagFilter},
+		Children: children,
+		F: func(ctx Context) error {
+			childName, rest := splitChildName(*ctx.self, ctx.Extra)
+			if childName != "" {
+				return runChild(ctx, childName, rest)
+			}
+			groups := tagGroups(tagOrder, ctx.self.Children)
+			if tags := tagFilter.Load(ctx); len(tags) > 0 {
+				groups = slices.DeleteFunc(groups, func(g Group) bool {
+					return !slices.Contains(tags, g.Title)
+				})
+				if len(groups) == 0 {
+					return &UsageError{Kind: UsageInvalid, Err: fmt.Errorf("no commands are tagged %s", strings.Join(tags, " or "))}
+				}
+			}
+			printGroups(ctx, md, groups)
+			return nil
+		},
+	}
+}
+
+// UntaggedTitle is the title of the group for children without any tags in NewTaggedDir.
+const UntaggedTitle = "Other"
+
+// tagGroups returns a Group for each tag used by the children, in the order described by NewTaggedDir.
+func tagGroups(tagOrder []string, children map[string]Command) []Group {
+	byTag := make(map[string][]string)
+	var untagged []string
+	for _, name := range sortedKeys(children) {
+		child := children[name]
+		if len(child.Tags) == 0 {
+			untagged = append(untagged, name)
+		}
+		for _, tag := range child.Tags {
+			if !slices.Contains(byTag[tag], name) {
+				byTag[tag] = append(byTag[tag], name)
+			}
+		}
+	}
+	var groups []Group
+	for _, tag := range tagOrder {
+		if names, exists := byTag[tag]; exists {
+			groups = append(groups, Group{Title: tag, Commands: names})
+			delete(byTag, tag)
+		}
+	}
+	for _, tag := range sortedKeys(byTag) {
+		groups = append(groups, Group{Title: tag, Commands: byTag[tag]})
+	}
+	if len(untagged) > 0 {
+		groups = append(groups, Group{Title: UntaggedTitle, Commands: untagged})
+	}
+	return groups
+}
+
+// printGroups lists the children of a directory in groups.
+func printGroups(ctx Context, md Metadata, groups []Group) {
+	ctx.Printf("%s\n\n", filepath.Base(ctx.CalledAs))
+	ctx.Printf("%s\n\n", md.Short)
+	for _, g := range groups {
+		ctx.Printf("%s:\n", g.Title)
+		names := slices.Clone(g.Commands)
+		slices.Sort(names)
+		fmtStr := "  %-" + strconv.Itoa(maxLen(names)) + "s  %s\n"
+		for _, cmdName := range names {
+			child, ok := ctx.self.Children[cmdName]
+			if !ok {
+				panic(fmt.Sprintf("No child command %q exists.  This is a bug.", cmdName))
+			}
+			if child.Hidden {
+				continue
+			}
+			ctx.Printf(fmtStr, cmdName, listingShort(child))
+		}
+		ctx.Printf("\n")
+	}
+	printDirFlags(ctx)
+}
+
+// printDirFlags lists the flags of a directory after its children, if it has any which are not hidden.
+func printDirFlags(ctx Context) {
+	for _, flag := range ctx.self.Flags {
+		if !flag.ParamInfo().Hidden {
+			ctx.Printf("FLAGS:\n")
+			writeFlags(ctx.StdOut, *ctx.self)
+			ctx.Printf("\n")
+			return
+		}
+	}
+}
+
+// visibleChildren returns the sorted names of the children which are not hidden.
+func visibleChildren(children map[string]Command) (ret []string) {
+	for k, child := range children {
+		if !child.Hidden {
+			ret = append(ret, k)
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// listingShort returns the description of a child in a directory listing.
+func listingShort(child Command) string {
+	if child.Deprecated != nil {
+		return strings.TrimSpace(child.Short + " (" + child.Deprecated.note() + ")")
+	}
+	return child.Short
+}
+
+// splitChildName returns the first argument which is not a flag or the value of a flag, and the rest of the arguments without it.
+// The value after a flag of cmd is skipped if the flag takes one.
+// The value after any other flag is skipped, unless it is the name of one of the children.
+func splitChildName(cmd Command, args []string) (childName string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, shortFlagPrefix) && !isFlag(arg) {
+			continue
+		}
+		if isFlag(arg) {
+			if hasInlineValue(arg) || i+1 >= len(args) {
+				continue
+			}
+			if flag, exists := cmd.Flags[strings.TrimPrefix(arg, flagPrefix)]; exists {
+				if takesValue(flag) {
+					i++
 				}
-				return Run(ctx.Context, child, ctx.Env, childName, rest, ctx.StdIn, ctx.StdOut, ctx.StdErr)
+				continue
 			}
-		}}
+			if _, isChild := cmd.Children[args[i+1]]; !isChild {
+				i++
+			}
+			continue
+		}
+		return arg, slices.Delete(args, i, i+1)
+	}
+	return "", nil
+}
+
+// runChild runs the child of the current command called childName.
+func runChild(ctx Context, childName string, rest []string) error {
+	child, ok := ctx.self.Children[childName]
+	if !ok {
+		return &UsageError{Kind: UsageUnknownCommand, Err: fmt.Errorf("no command found for %q", childName)}
+	}
+	return Run(withChildConfig(ctx.Context, childName), child, ctx.Env, childName, rest, ctx.StdIn, ctx.StdOut, ctx.StdErr)
+}
+
+type calledAsKey struct{}
+
+// recordCalledAs returns a copy of dir which records the name it was called as, for its children to read with parentCalledAs.
+// It is used by children like batch and shell, which run dir again.
+func recordCalledAs(dir Command) Command {
+	f := dir.F
+	dir.F = func(c Context) error {
+		c.Context = context.WithValue(c.Context, calledAsKey{}, c.CalledAs)
+		return f(c)
+	}
+	return dir
+}
+
+// parentCalledAs returns the name the parent of the current command was called as, if it was recorded by recordCalledAs.
+// Otherwise it returns the name the current command was called as.
+func parentCalledAs(c Context) string {
+	if calledAs, ok := c.Value(calledAsKey{}).(string); ok {
+		return calledAs
+	}
+	return c.CalledAs
+}
+
+// SkipChildren can be returned from the function passed to Walk, to skip the children of the current command.
+var SkipChildren = errors.New("skip children")
+
+// Walk calls fn for cmd, and each of its descendants, depth first.
+// The children of each command are visited in order by name.
+// path is the list of names used to get from cmd to c, and is empty for cmd.
+func Walk(cmd Command, fn func(path []string, c Command) error) error {
+	return walk(nil, cmd, fn)
+}
+
+func walk(path []string, cmd Command, fn func(path []string, c Command) error) error {
+	if err := fn(path, cmd); err == SkipChildren {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	keys := maps.Keys(cmd.Children)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := walk(append(path[:len(path):len(path)], k), cmd.Children[k], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the descendant of cmd reached by following the names in path.
+// Lookup returns cmd if path is empty, and false if there is no such command.
+func Lookup(cmd Command, path []string) (Command, bool) {
+	for _, name := range path {
+		child, ok := cmd.Children[name]
+		if !ok {
+			return Command{}, false
+		}
+		cmd = child
+	}
+	return cmd, true
 }
 
 func maxLen[T ~string](xs []T) (ret int) {
@@ -0,0 +1,83 @@
+package star
+
+import "errors"
+
+// UsageErrorKind is the kind of mistake which caused a UsageError.
+type UsageErrorKind int
+
+const (
+	// UsageFlag is for a flag which is missing its value, or has a value which could not be parsed.
+	UsageFlag = UsageErrorKind(iota + 1)
+	// UsagePositional is for a positional argument which could not be parsed.
+	UsagePositional
+	// UsageLayer is for a value from the environment or a config file which could not be parsed.
+	UsageLayer
+	// UsageMissing is for a parameter which was not given enough values.
+	UsageMissing
+	// UsageTooMany is for a parameter which was given too many values.
+	UsageTooMany
+	// UsageInvalid is for values which were rejected by their parameter.
+	UsageInvalid
+	// UsageRule is for a violation of one of the command's Rules.
+	UsageRule
+	// UsageUnknownCommand is for the name of a child which does not exist in a directory.
+	UsageUnknownCommand
+)
+
+func (k UsageErrorKind) String() string {
+	switch k {
+	case UsageFlag:
+		return "flag"
+	case UsagePositional:
+		return "positional"
+	case UsageLayer:
+		return "layer"
+	case UsageMissing:
+		return "missing"
+	case UsageTooMany:
+		return "too-many"
+	case UsageInvalid:
+		return "invalid"
+	case UsageRule:
+		return "rule"
+	case UsageUnknownCommand:
+		return "unknown-command"
+	default:
+		return "unknown"
+	}
+}
+
+// UsageError is returned by Run when a command is invoked incorrectly,
+// as opposed to an error returned by the command itself.
+type UsageError struct {
+	Kind UsageErrorKind
+	Err  error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// IsUsageError returns true if err is, or wraps, a UsageError of kind k.
+func IsUsageError(err error, k UsageErrorKind) bool {
+	var uerr *UsageError
+	return errors.As(err, &uerr) && uerr.Kind == k
+}
+
+// ExitCode returns the exit code a process should use after a command returns err.
+// It is 0 for a nil error, 2 for a UsageError, and 1 otherwise.
+func ExitCode(err error) int {
+	var uerr *UsageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &uerr):
+		return 2
+	default:
+		return 1
+	}
+}
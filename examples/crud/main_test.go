@@ -14,3 +14,7 @@ func TestCommand(t *testing.T) {
 
 	teststar.OutContainsString(t, &rootCmd, []string{"sub-dir-command", "echo-pos", "foobar1"}, "foobar1")
 }
+
+func TestLint(t *testing.T) {
+	teststar.Lint(t, &rootCmd)
+}
@@ -0,0 +1,198 @@
+package star
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatter converts a value of type T to a string, which a Parser for T will parse back to the same value.
+type Formatter[T any] = func(T) string
+
+// ArgFormatter is implemented by parameters which can convert their values back into arguments.
+// All of the parameter kinds in this package implement it.
+type ArgFormatter interface {
+	// FormatArg returns the argument which ParseArg would parse into v.
+	FormatArg(v any) (string, error)
+}
+
+// FormatArgs returns arguments for cmd which Run will parse into vals.
+// It is the inverse of parsing: each value is formatted with its parameter's FormatArg,
+// and the arguments are arranged as in BuildArgs.
+// Values of Secret parameters are left out, so the arguments are safe to display.
+func FormatArgs(cmd Command, vals map[Parameter][]any) ([]string, error) {
+	names := displayNames(cmd.canonicalFlags(), cmd.Pos)
+	strs := make(map[Parameter][]string)
+	for param, vs := range vals {
+		if param.ParamInfo().Secret {
+			continue
+		}
+		af, ok := param.(ArgFormatter)
+		if !ok {
+			return nil, fmt.Errorf("parameter %s cannot format its values", names[param])
+		}
+		for _, v := range vs {
+			s, err := af.FormatArg(v)
+			if err != nil {
+				return nil, fmt.Errorf("formatting value for parameter %s: %w", names[param], err)
+			}
+			strs[param] = append(strs[param], s)
+		}
+	}
+	return buildArgs(cmd, strs)
+}
+
+// ContextArgs returns arguments which reproduce the values in c that were passed as arguments.
+// Values from the environment, a config file, or defaults are left out, so they are not pinned when the arguments are used again,
+// and so are the values of Secret parameters.  See FormatArgs.
+func ContextArgs(c Context) ([]string, error) {
+	vals := make(map[Parameter][]any)
+	for param, vs := range c.Values {
+		srcs := c.Sources[param]
+		for i, v := range vs {
+			if i < len(srcs) && srcs[i].Kind != SourceFlag && srcs[i].Kind != SourcePositional {
+				continue
+			}
+			vals[param] = append(vals[param], v)
+		}
+	}
+	return FormatArgs(*c.self, vals)
+}
+
+// formatDefaults formats the defaults of p as they would be passed as arguments.  See formatValue.
+// The defaults of a Counter are formatted as the count.
+func formatDefaults(p Parameter, defaults []any) []string {
+	if _, isCounter := p.(*Counter); isCounter && len(defaults) > 0 {
+		return []string{strconv.Itoa(len(defaults))}
+	}
+	var ret []string
+	for _, v := range defaults {
+		ret = append(ret, formatValue(p, v))
+	}
+	return ret
+}
+
+// formatValue formats v as it would be passed as an argument for p, using FormatArg if p implements it.
+// Values which cannot be formatted that way, or which are for flags that take no value, are written with fmt.Sprint.
+func formatValue(p Parameter, v any) string {
+	if af, ok := p.(ArgFormatter); ok && !p.ParamInfo().NoValue {
+		if x, err := af.FormatArg(v); err == nil {
+			return x
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// formatWith formats v using the name of a choice, format, or a formatter inferred from its type, in that order.
+func formatWith[T any](format Formatter[T], choices Choices[T], v any) (string, error) {
+	x, ok := v.(T)
+	if !ok {
+		return "", fmt.Errorf("value %v is a %T, not a %s", v, v, typeName[T]())
+	}
+	if choices != nil {
+		for _, name := range choices.Names() {
+			if reflect.DeepEqual(choices[name], x) {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("value %v is not one of the choices", v)
+	}
+	if format != nil {
+		return format(x), nil
+	}
+	return formatReflect(x)
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+// formatReflect formats the types which reflectParser can parse.
+func formatReflect(v any) (string, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return "", fmt.Errorf("cannot format nil")
+	}
+	ty := rv.Type()
+	if ty.Implements(textMarshalerType) {
+		data, err := v.(encoding.TextMarshaler).MarshalText()
+		return string(data), err
+	}
+	if ty == durationType {
+		return v.(time.Duration).String(), nil
+	}
+	switch ty.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, ty.Bits()), nil
+	default:
+		return "", fmt.Errorf("no formatter for type %v, set Format on the parameter", ty)
+	}
+}
+
+// joinList is the inverse of splitList.
+func joinList(fields []string, sep rune) (string, error) {
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if len(fields) == 1 && fields[0] == "" {
+		// a single empty field would be written as an empty line, which parses to no fields.
+		return `""`, nil
+	}
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Comma = sep
+	if err := w.Write(fields); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// QuoteArgs joins args into a single line, quoting them as needed for a POSIX shell.
+// SplitArgs will split the line back into args.
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArg(x string) string {
+	if x == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range x {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return x
+	}
+	return "'" + strings.ReplaceAll(x, "'", `'\''`) + "'"
+}
+
+func isShellSafe(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("@%+=:,./_-", r)
+	}
+}
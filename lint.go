@@ -0,0 +1,106 @@
+package star
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// LintIssue is a likely mistake in the definition of a command.
+type LintIssue struct {
+	// Path is the list of names used to get from the root to the command with the issue.
+	Path    []string
+	Message string
+}
+
+func (li LintIssue) String() string {
+	return fmt.Sprintf("%s: %s", pathString(li.Path), li.Message)
+}
+
+// Lint checks cmd, and all of its descendants, for common mistakes.
+// It checks for:
+//   - commands without Metadata.Short.
+//   - positional parameters without a PosName.
+//   - positional parameters which require a value, after one which accepts many.
+//   - flags which are shadowed by a flag with the same name on a parent directory.
+//   - groups which reference children which do not exist.
+//   - deprecated flag names which are not flags.
+//   - parameters used more than once as positional arguments, or as both a positional argument and a flag.
+func Lint(cmd Command) []LintIssue {
+	var ret []LintIssue
+	lint(&ret, nil, cmd, map[string][]string{})
+	return ret
+}
+
+// lint appends the issues for cmd to dst, and then recurses into its children.
+// inherited maps flag names from parent directories to the path of the directory which has them.
+func lint(dst *[]LintIssue, path []string, cmd Command, inherited map[string][]string) {
+	report := func(format string, args ...any) {
+		*dst = append(*dst, LintIssue{Path: append([]string{}, path...), Message: fmt.Sprintf(format, args...)})
+	}
+	if cmd.Short == "" {
+		report("missing Metadata.Short")
+	}
+
+	var variadic string
+	seen := make(map[Parameter]string)
+	for i, pos := range cmd.Pos {
+		info := pos.ParamInfo()
+		name := info.PosName
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+			report("positional parameter at index %d has no PosName", i)
+		}
+		if prev, exists := seen[pos]; exists {
+			report("positional parameter %s is the same as %s", name, prev)
+		}
+		seen[pos] = name
+		if variadic != "" && info.MinCount > 0 {
+			report("positional parameter %s requires a value, but comes after %s which accepts many", name, variadic)
+		}
+		if variadic == "" && info.MaxCount > 1 {
+			variadic = name
+		}
+	}
+	for _, k := range sortedKeys(cmd.Flags) {
+		if name, exists := seen[cmd.Flags[k]]; exists {
+			report("flag %s is the same parameter as positional %s", flagName(k), name)
+		}
+		if dirPath, exists := inherited[k]; exists {
+			report("flag %s is shadowed by the same flag on %s", flagName(k), pathString(dirPath))
+		}
+	}
+
+	for _, k := range sortedKeys(cmd.DeprecatedFlags) {
+		if _, exists := cmd.Flags[k]; !exists {
+			report("deprecated flag %s is not one of the command's flags", flagName(k))
+		}
+	}
+
+	for _, g := range cmd.Groups {
+		for _, name := range g.Commands {
+			if _, exists := cmd.Children[name]; !exists {
+				report("group %q references child %q which does not exist", g.Title, name)
+			}
+		}
+	}
+	if len(cmd.Children) > 0 && len(cmd.Flags) > 0 {
+		inherited = maps.Clone(inherited)
+		for k := range cmd.Flags {
+			if _, exists := inherited[k]; !exists {
+				inherited[k] = path
+			}
+		}
+	}
+	for _, k := range sortedKeys(cmd.Children) {
+		lint(dst, append(append([]string{}, path...), k), cmd.Children[k], inherited)
+	}
+}
+
+func pathString(path []string) string {
+	if len(path) == 0 {
+		return "(root)"
+	}
+	return strings.Join(path, " ")
+}
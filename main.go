@@ -2,7 +2,9 @@ package star
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -17,12 +19,6 @@ import (
 //	  star.Main(yourCommandHere)
 //	}
 func Main(c Command, opts ...MainOption) {
-	calledAs := os.Args[0]
-	args := os.Args[1:]
-	stdin := os.Stdin
-	stdout := os.Stdout
-	stderr := os.Stderr
-
 	// setup the default config
 	cfg := mainConfig{
 		Background: func() context.Context {
@@ -38,15 +34,47 @@ func Main(c Command, opts ...MainOption) {
 	for _, opt := range opts {
 		opt(&cfg)
 	}
-	if err := Run(cfg.Background, c, cfg.Env, calledAs, args, stdin, stdout, stderr); err != nil {
+	if err := runMain(cfg, c, os.Args[0], os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		os.Exit(ExitCode(err))
 	}
 }
 
+// runMain does the work of Main for the configuration cfg, and returns the error instead of exiting.
+func runMain(cfg mainConfig, c Command, calledAs string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	bgCtx := cfg.Background
+	if cfg.ConfigApp != "" {
+		var cfgPath string
+		cfgPath, args = cutConfigFlag(c, args)
+		conf, err := loadMainConfig(cfg.ConfigApp, cfgPath)
+		if err != nil {
+			return err
+		}
+		bgCtx = WithConfig(bgCtx, conf)
+	}
+	if cfg.PrintConfig {
+		bgCtx = WithPrintConfig(bgCtx)
+	}
+	if cfg.MultiCall {
+		bgCtx, c, calledAs, _ = multiCallChild(bgCtx, c, calledAs)
+	}
+	if cfg.Version {
+		var version bool
+		if c, version = withVersionFlag(c, args); version {
+			_, err := ReadVersionInfo().WriteTo(stdout)
+			return err
+		}
+	}
+	return Run(bgCtx, c, cfg.Env, calledAs, args, stdin, stdout, stderr)
+}
+
 type mainConfig struct {
-	Background context.Context
-	Env        map[string]string
+	Background  context.Context
+	Env         map[string]string
+	ConfigApp   string
+	PrintConfig bool
+	MultiCall   bool
+	Version     bool
 }
 
 // MainOption configures the behavior off Main
@@ -73,6 +101,38 @@ func MainIncludeEnv(filter func(string) bool) MainOption {
 	}
 }
 
+// MainConfigFile returns a MainOption which loads a config file to provide values for parameters.
+// The path of the file is taken from the --config flag if it is passed.
+// Otherwise the file at DefaultConfigPath(appName) is used, if it exists.
+func MainConfigFile(appName string) MainOption {
+	return func(cfg *mainConfig) {
+		cfg.ConfigApp = appName
+	}
+}
+
+// MainPrintConfig returns a MainOption which enables the --print-config flag for all commands.
+// See WithPrintConfig
+func MainPrintConfig() MainOption {
+	return func(cfg *mainConfig) {
+		cfg.PrintConfig = true
+	}
+}
+
+func loadMainConfig(appName, p string) (*Config, error) {
+	if p != "" {
+		return LoadConfig(p)
+	}
+	p, err := DefaultConfigPath(appName)
+	if err != nil {
+		return nil, nil
+	}
+	conf, err := LoadConfig(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return conf, err
+}
+
 // OSEnv reads from os.Environ() and copies items to dst if they match filter.
 func OSEnv(dst map[string]string, filter func(string) bool) {
 	for _, pair := range os.Environ() {
@@ -0,0 +1,98 @@
+package star
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// MainMultiCall returns a MainOption which dispatches on the name the program was called as, like busybox.
+// If the base name of os.Args[0] is the name of a child of the root directory command,
+// then that child is run with all of the arguments, as if the name had been passed as the first argument.
+// Otherwise the root command is run as usual.
+//
+// See WithInstallLinks, for a command which creates the links to call the program by each name.
+func MainMultiCall() MainOption {
+	return func(cfg *mainConfig) {
+		cfg.MultiCall = true
+	}
+}
+
+// multiCallChild returns the child of cmd named by the base name of calledAs, if there is one.
+// The context is scoped to the child's section of the config, as it would be by the directory.
+func multiCallChild(ctx context.Context, cmd Command, calledAs string) (context.Context, Command, string, bool) {
+	name := strings.TrimSuffix(filepath.Base(calledAs), ".exe")
+	child, exists := cmd.Children[name]
+	if !exists {
+		return ctx, cmd, calledAs, false
+	}
+	return withChildConfig(ctx, name), child, name, true
+}
+
+// WithInstallLinks returns a copy of the directory command dir, with an install-links child
+// which creates a link to the running executable for each of the visible children of dir.
+// The children added by WithShell, WithBatch, WithVersion and WithInstallLinks are skipped,
+// since they are not useful by themselves, and could shadow other programs e.g. batch.
+// On Windows the links are given the .exe extension, so they can be run by name.
+// It is meant to be used with MainMultiCall.
+func WithInstallLinks(dir Command) Command {
+	target := &Required[string]{
+		PosName:  "dir",
+		Parse:    ParseString,
+		ShortDoc: "the directory to create the links in",
+	}
+	hard := &Boolean{ShortDoc: "create hard links instead of symbolic links"}
+	force := &Boolean{ShortDoc: "replace existing files"}
+	dir.Children = maps.Clone(dir.Children)
+	dir.Children["install-links"] = Command{
+		Metadata: Metadata{Short: "creates a link to this program for each command, to call it by that name"},
+		Pos:      []Positional{target},
+		Flags: map[string]Flag{
+			"hard":  hard,
+			"force": force,
+		},
+		F: func(c Context) error {
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			if exe, err = filepath.EvalSymlinks(exe); err != nil {
+				return err
+			}
+			for _, name := range visibleChildren(dir.Children) {
+				if dir.Children[name].helper {
+					continue
+				}
+				p := filepath.Join(target.Load(c), exeName(name, runtime.GOOS))
+				if force.Load(c) {
+					if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+						return err
+					}
+				}
+				link := os.Symlink
+				if hard.Load(c) {
+					link = os.Link
+				}
+				if err := link(exe, p); err != nil {
+					return err
+				}
+				c.Printf("%s -> %s\n", p, exe)
+			}
+			return nil
+		},
+		helper: true,
+	}
+	return dir
+}
+
+// exeName returns the file name for an executable called name on the operating system goos.
+func exeName(name, goos string) string {
+	if goos == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
@@ -3,6 +3,10 @@ package star
 import (
 	"fmt"
 	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 
 	"go.brendoncarroll.net/exp/slices2"
 )
@@ -15,31 +19,76 @@ type Parser[T any] = func(string) (T, error)
 // - Required
 // - Optional
 // - Repeated
+// - List
+// - Map
+// - Counter
+//
+// Other packages can define new kinds of parameters by implementing Parameter,
+// and Positional or Flag.
+// Values parsed by ParseArg are stored in Context.Values, and can be retrieved with Context.ValuesOf.
 type Parameter interface {
-	isParam()
-
-	getShortDoc() string
-	parse(string) (any, error)
-	minCount() int
-	maxCount() int
+	// ParamInfo returns information about the parameter, which is used to parse, check, and document it.
+	ParamInfo() ParamInfo
+	// ParseArg parses a single argument into a value.
+	// Parameters which take no value (see ParamInfo.NoValue) are passed the empty string.
+	ParseArg(x string) (any, error)
 }
 
-type posNamer interface {
-	getPosName() string
+// ParamInfo is information about a Parameter.
+type ParamInfo struct {
+	// ShortDoc is a short description of the parameter, used in the help text.
+	ShortDoc string
+	// PosName is the name of the parameter when it is used positionally.
+	PosName string
+	// MinCount is the minimum number of values which must be provided.
+	MinCount int
+	// MaxCount is the maximum number of values which can be provided.
+	// Use math.MaxInt if there is no maximum, a MaxCount of 0 means the parameter accepts no values.
+	MaxCount int
+	// NoValue is true for flags which do not take a value e.g. --verbose
+	NoValue bool
+
+	// Choices are the only values accepted by the parameter, if it has a fixed set.
+	Choices []string
+	// Notes describe constraints on the values, and are shown in the help text.
+	Notes []string
+
+	// Env is the name of an environment variable which can provide values.
+	Env string
+	// ConfigKey is the key in a config file which can provide values.
+	ConfigKey string
+	// Defaults are the values used if no others are provided.
+	Defaults []any
+	// Secret is true if the values should not be printed.
+	Secret bool
+	// Type is the name of the type of the parsed values e.g. int
+	Type string
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated parameters can be used, but print a warning when they are provided as arguments.
+	Deprecated *Deprecation
+}
+
+// ValuesChecker is implemented by parameters which have constraints across all of their values.
+type ValuesChecker interface {
+	CheckValues(vals []any) error
 }
 
 // Positional is a parameter that can be used as a positional argument
 type Positional interface {
 	Parameter
 
-	usagePositional(name string) string
+	// UsagePositional returns the parameter as it appears in a usage line e.g. <name>
+	UsagePositional(name string) string
 }
 
 // Flag is a parameter which can be specified on the command line
 type Flag interface {
 	Parameter
 
-	usageFlag(name string) string
+	// UsageFlag returns a short description of how the flag is passed e.g. (required)
+	UsageFlag(name string) string
 }
 
 // Required is a required parameter
@@ -49,8 +98,32 @@ type Required[T any] struct {
 	PosName string
 
 	Parse Parser[T]
+	// Format, if set, is the inverse of Parse, and is used to turn values back into arguments.
+	// If it is not set, then a formatter is inferred from T.  See FormatArgs.
+	Format Formatter[T]
+	// Choices, if set, are the only values accepted, and Parse is not used.
+	Choices Choices[T]
+	// Checks are applied to each value after it is parsed.
+	Checks []Check[T]
+
+	// Env is the name of an environment variable which provides a value if none is passed as an argument.
+	Env string
+	// ConfigKey is the key in the config file which provides a value if none is passed as an argument or in the environment.
+	ConfigKey string
+	// Secret parameters have their values redacted when printed.
+	Secret bool
+	// Default is the value used if no other value is provided.
+	Default *T
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
 
 	ShortDoc string
+
+	// typ overrides the name of T in ParamInfo.  It is set by NewCommand, where T is any.
+	typ string
 }
 
 func (p *Required[T]) Load(c Context) T {
@@ -58,34 +131,39 @@ func (p *Required[T]) Load(c Context) T {
 	return c.Values[p][0].(T)
 }
 
-func (p *Required[T]) parse(x string) (any, error) {
-	return p.Parse(x)
+func (p *Required[T]) ParseArg(x string) (any, error) {
+	v, err := parseWith(p.Parse, p.Choices, x)
+	return applyChecks(p.Checks, v, err)
 }
 
-func (p *Required[T]) isParam() {}
+func (p *Required[T]) FormatArg(v any) (string, error) {
+	return formatWith(p.Format, p.Choices, v)
+}
 
-func (p *Required[T]) usagePositional(name string) string {
+func (p *Required[T]) UsagePositional(name string) string {
 	return fmt.Sprintf("<%v>", name)
 }
 
-func (p *Required[T]) usageFlag(name string) string {
+func (p *Required[T]) UsageFlag(name string) string {
 	return "(required)"
 }
 
-func (p *Required[T]) minCount() int {
-	return 1
-}
-
-func (p *Required[T]) maxCount() int {
-	return 1
-}
-
-func (p *Required[T]) getShortDoc() string {
-	return p.ShortDoc
-}
-
-func (p *Required[T]) getPosName() string {
-	return p.PosName
+func (p *Required[T]) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		PosName:    p.PosName,
+		MinCount:   1,
+		MaxCount:   1,
+		Choices:    p.Choices.Names(),
+		Notes:      checkDocs(p.Checks),
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   ptrDefaults(p.Default),
+		Secret:     p.Secret,
+		Type:       typeNameOr[T](p.typ),
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
 }
 
 var _ Parameter = &Optional[struct{}]{}
@@ -96,10 +174,34 @@ type Optional[T any] struct {
 	PosName string
 
 	Parse Parser[T]
+	// Format, if set, is the inverse of Parse, and is used to turn values back into arguments.
+	// If it is not set, then a formatter is inferred from T.  See FormatArgs.
+	Format Formatter[T]
+	// Choices, if set, are the only values accepted, and Parse is not used.
+	Choices Choices[T]
+	// Checks are applied to each value after it is parsed.
+	Checks []Check[T]
+
+	// Env is the name of an environment variable which provides a value if none is passed as an argument.
+	Env string
+	// ConfigKey is the key in the config file which provides a value if none is passed as an argument or in the environment.
+	ConfigKey string
+	// Secret parameters have their values redacted when printed.
+	Secret bool
+	// Default is the value used if no other value is provided.
+	Default *T
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
 
 	// ShortDoc is a short description of the parameter, used in the help text.
 	// It should be less than a single line of text.
 	ShortDoc string
+
+	// typ overrides the name of T in ParamInfo.  It is set by NewCommand, where T is any.
+	typ string
 }
 
 // Load loads the value for an optional parameter
@@ -113,45 +215,79 @@ func (opt *Optional[T]) LoadOpt(c Context) (T, bool) {
 	return vals[0].(T), true
 }
 
-func (p *Optional[T]) parse(x string) (any, error) {
-	return p.Parse(x)
-}
-
-func (p *Optional[T]) getShortDoc() string {
-	return p.ShortDoc
+func (p *Optional[T]) ParseArg(x string) (any, error) {
+	v, err := parseWith(p.Parse, p.Choices, x)
+	return applyChecks(p.Checks, v, err)
 }
 
-func (p *Optional[T]) getPosName() string {
-	return p.PosName
+func (p *Optional[T]) FormatArg(v any) (string, error) {
+	return formatWith(p.Format, p.Choices, v)
 }
 
-func (opt *Optional[T]) usagePositional(name string) string {
+func (p *Optional[T]) UsagePositional(name string) string {
 	return fmt.Sprintf("[%v]", name)
 }
 
-func (opt *Optional[T]) usageFlag(name string) string {
-	return "(required)"
-}
-
-func (opt *Optional[T]) minCount() int {
-	return 0
-}
-
-func (opt *Optional[T]) maxCount() int {
-	return 1
+func (p *Optional[T]) UsageFlag(name string) string {
+	return "(optional)"
+}
+
+func (p *Optional[T]) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		PosName:    p.PosName,
+		MinCount:   0,
+		MaxCount:   1,
+		Choices:    p.Choices.Names(),
+		Notes:      checkDocs(p.Checks),
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   ptrDefaults(p.Default),
+		Secret:     p.Secret,
+		Type:       typeNameOr[T](p.typ),
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
 }
 
-func (opt *Optional[T]) isParam() {}
-
 // Repeated is a parameter that can be passed as a flag multiple times.
+// As a positional parameter, it consumes up to Max arguments, leaving enough for any parameters after it.
 type Repeated[T any] struct {
 	// PosName is only used for positional parameters in doc/error messages.
 	PosName string
 
 	Parse Parser[T]
-	Min   int
+	// Format, if set, is the inverse of Parse, and is used to turn values back into arguments.
+	// If it is not set, then a formatter is inferred from T.  See FormatArgs.
+	Format Formatter[T]
+	// Choices, if set, are the only values accepted, and Parse is not used.
+	Choices Choices[T]
+	// Checks are applied to each value after it is parsed.
+	Checks []Check[T]
+
+	// Env is the name of an environment variable which provides a value if none is passed as an argument.
+	Env string
+	// ConfigKey is the key in the config file which provides a value if none is passed as an argument or in the environment.
+	ConfigKey string
+	// Secret parameters have their values redacted when printed.
+	Secret bool
+	// Default is the values used if no other values are provided.
+	Default []T
+	// Min is the minimum number of values which must be provided.
+	Min int
+	// Max is the maximum number of values which can be provided.
+	// If Max is 0, then there is no maximum.
+	Max int
+
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
 
 	ShortDoc string
+
+	// typ overrides the name of T in ParamInfo.  It is set by NewCommand, where T is any.
+	typ string
 }
 
 func (r *Repeated[T]) Load(c Context) []T {
@@ -162,38 +298,260 @@ func (r *Repeated[T]) Load(c Context) []T {
 	})
 }
 
-func (p *Repeated[T]) parse(x string) (any, error) {
-	return p.Parse(x)
+func (p *Repeated[T]) ParseArg(x string) (any, error) {
+	v, err := parseWith(p.Parse, p.Choices, x)
+	return applyChecks(p.Checks, v, err)
 }
 
-func (p *Repeated[T]) getShortDoc() string {
-	return p.ShortDoc
+func (p *Repeated[T]) FormatArg(v any) (string, error) {
+	return formatWith(p.Format, p.Choices, v)
 }
 
-func (p *Repeated[T]) getPosName() string {
-	return p.PosName
-}
-
-func (r *Repeated[T]) usagePositional(name string) string {
-	return fmt.Sprintf("[%s ...]", name)
+func (r *Repeated[T]) UsagePositional(name string) string {
+	switch {
+	case r.Min == 0 && r.Max == 0:
+		return fmt.Sprintf("[%s ...]", name)
+	case r.Max == 0:
+		return fmt.Sprintf("<%s>...", name)
+	default:
+		return fmt.Sprintf("<%s>{%d,%d}", name, r.Min, r.Max)
+	}
 }
 
-func (r *Repeated[T]) usageFlag(name string) string {
-	return "(repeated)"
+func (r *Repeated[T]) UsageFlag(name string) string {
+	switch {
+	case r.Min == 0 && r.Max == 0:
+		return "(repeated)"
+	case r.Max == 0:
+		return fmt.Sprintf("(repeated, at least %d)", r.Min)
+	default:
+		return fmt.Sprintf("(repeated, %d to %d)", r.Min, r.Max)
+	}
 }
 
-func (r *Repeated[T]) minCount() int {
-	return 0
+func (p *Repeated[T]) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		PosName:    p.PosName,
+		MinCount:   p.Min,
+		MaxCount:   p.maxCount(),
+		Choices:    p.Choices.Names(),
+		Notes:      checkDocs(p.Checks),
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   sliceDefaults(p.Default),
+		Secret:     p.Secret,
+		Type:       typeNameOr[T](p.typ),
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
 }
 
 func (r *Repeated[T]) maxCount() int {
+	if r.Max > 0 {
+		return r.Max
+	}
 	return math.MaxInt
 }
 
-func (r *Repeated[T]) isParam() {}
+// Choices maps the strings accepted by a parameter to typed values.
+type Choices[T any] map[string]T
+
+// ChoicesOf returns Choices which accept each of xs as itself.
+func ChoicesOf(xs ...string) Choices[string] {
+	ret := make(Choices[string], len(xs))
+	for _, x := range xs {
+		ret[x] = x
+	}
+	return ret
+}
+
+// Names returns the accepted strings in sorted order.
+func (cs Choices[T]) Names() []string {
+	if len(cs) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(cs))
+	for k := range cs {
+		ret = append(ret, k)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// Parse is a Parser which only accepts one of the choices.
+func (cs Choices[T]) Parse(x string) (T, error) {
+	if v, ok := cs[x]; ok {
+		return v, nil
+	}
+	var zero T
+	return zero, fmt.Errorf("invalid value %q, must be one of: %s", x, strings.Join(cs.Names(), ", "))
+}
+
+func typeName[T any]() string {
+	return reflect.TypeFor[T]().String()
+}
+
+// typeNameOr returns name if it is set, and the name of T otherwise.
+func typeNameOr[T any](name string) string {
+	if name != "" {
+		return name
+	}
+	return typeName[T]()
+}
+
+func ptrDefaults[T any](x *T) []any {
+	if x == nil {
+		return nil
+	}
+	return []any{*x}
+}
+
+func sliceDefaults[T any](xs []T) []any {
+	if len(xs) == 0 {
+		return nil
+	}
+	ret := make([]any, len(xs))
+	for i := range xs {
+		ret[i] = xs[i]
+	}
+	return ret
+}
+
+func parseWith[T any](parse Parser[T], choices Choices[T], x string) (any, error) {
+	if choices != nil {
+		return choices.Parse(x)
+	}
+	return parse(x)
+}
+
+// Counter is a flag which takes no value, and counts the number of times it is provided.
+// e.g. -v, -vvv or --verbose --verbose
+type Counter struct {
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
+
+	// Env is the name of an environment variable which provides the count if the flag is not passed.
+	// Its value can be a number, or a boolean.
+	Env string
+	// ConfigKey is the key in the config file which provides the count if the flag is not passed or in the environment.
+	ConfigKey string
+	// Default is the count used if no other value is provided.
+	Default int
+
+	ShortDoc string
+}
+
+// Load returns the number of times the flag was provided.
+func (p *Counter) Load(c Context) int {
+	panicIfNotHas(p, c)
+	return len(c.Values[p])
+}
+
+func (p *Counter) ParseArg(string) (any, error) {
+	return struct{}{}, nil
+}
+
+func (p *Counter) FormatArg(any) (string, error) {
+	return "", nil
+}
+
+// parseLayer parses a count from the environment or a config file.
+func (p *Counter) parseLayer(x string) ([]any, error) {
+	n, err := strconv.Atoi(x)
+	if err != nil {
+		b, berr := strconv.ParseBool(x)
+		if berr != nil {
+			return nil, fmt.Errorf("%q is not a count", x)
+		}
+		n = 0
+		if b {
+			n = 1
+		}
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("count %d is negative", n)
+	}
+	return p.values(n), nil
+}
+
+// values returns n values, as if the flag were passed n times.
+func (p *Counter) values(n int) []any {
+	var ret []any
+	for range n {
+		ret = append(ret, struct{}{})
+	}
+	return ret
+}
+
+func (p *Counter) UsageFlag(name string) string {
+	return "(count)"
+}
+
+func (p *Counter) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		MinCount:   0,
+		MaxCount:   math.MaxInt,
+		NoValue:    true,
+		Env:        p.Env,
+		ConfigKey:  p.ConfigKey,
+		Defaults:   p.values(p.Default),
+		Type:       "int",
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
+}
 
-// Boolean is a Parameter that either exists or doesn't
+// Boolean is a flag which takes no value, and is true if it is provided.
+// It can be provided at most once.
+// e.g. --force
 type Boolean struct {
+	// Hidden parameters can be used, but are not shown in help text or completion.
+	Hidden bool
+	// Deprecated, if set, causes a warning to be printed when the parameter is provided as an argument.
+	Deprecated *Deprecation
+
+	ShortDoc string
+}
+
+// Load returns true if the flag was provided.
+func (p *Boolean) Load(c Context) bool {
+	panicIfNotHas(p, c)
+	return len(c.Values[p]) > 0
+}
+
+func (p *Boolean) ParseArg(string) (any, error) {
+	return true, nil
+}
+
+func (p *Boolean) FormatArg(any) (string, error) {
+	return "", nil
+}
+
+func (p *Boolean) UsageFlag(name string) string {
+	return "(switch)"
+}
+
+func (p *Boolean) ParamInfo() ParamInfo {
+	return ParamInfo{
+		ShortDoc:   p.ShortDoc,
+		MinCount:   0,
+		MaxCount:   1,
+		NoValue:    true,
+		Type:       "bool",
+		Hidden:     p.Hidden,
+		Deprecated: p.Deprecated,
+	}
+}
+
+// ValuesOf returns the values for a parameter.
+// It panics if the parameter is not one of the command's parameters.
+func (c Context) ValuesOf(p Parameter) []any {
+	panicIfNotHas(p, c)
+	return c.Values[p]
 }
 
 func panicIfNotHas(param Parameter, c Context) {
@@ -0,0 +1,164 @@
+package star
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"golang.org/x/exp/maps"
+)
+
+// SchemaVersion is the version of the format produced by NewSchema.
+// It will be incremented when changes are made which could break consumers.
+const SchemaVersion = 1
+
+// Schema is a machine readable description of a command tree.
+type Schema struct {
+	Version int `json:"version"`
+	// Commands has an entry for every command in the tree, including the root.
+	Commands []CommandSchema `json:"commands"`
+}
+
+// CommandSchema describes a single command in a tree.
+type CommandSchema struct {
+	// Path is the list of names used to get from the root to this command.
+	Path   []string `json:"path"`
+	Short  string   `json:"short,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Hidden bool     `json:"hidden,omitempty"`
+	// Deprecated is the deprecation notice, if the command is deprecated.
+	Deprecated string `json:"deprecated,omitempty"`
+	// Children are the names of the command's children, if it is a directory.
+	Children []string `json:"children,omitempty"`
+
+	// Positional is in the order the arguments are passed.
+	Positional []ParamSchema `json:"positional,omitempty"`
+	// Flags are in order by name.
+	Flags []ParamSchema `json:"flags,omitempty"`
+}
+
+// ParamSchema describes a single parameter.
+type ParamSchema struct {
+	// Name is the positional name, or the flag name without dashes.
+	Name string `json:"name"`
+	// Aliases are other flag names for the same parameter.
+	Aliases []string `json:"aliases,omitempty"`
+	Doc     string   `json:"doc,omitempty"`
+	Type    string   `json:"type,omitempty"`
+
+	MinCount int `json:"min_count"`
+	// MaxCount is omitted if there is no maximum.
+	MaxCount *int `json:"max_count,omitempty"`
+	NoValue  bool `json:"no_value,omitempty"`
+
+	Choices   []string `json:"choices,omitempty"`
+	Defaults  []string `json:"defaults,omitempty"`
+	Env       string   `json:"env,omitempty"`
+	ConfigKey string   `json:"config_key,omitempty"`
+	Secret    bool     `json:"secret,omitempty"`
+
+	Hidden bool `json:"hidden,omitempty"`
+	// Deprecated is the deprecation notice, if the parameter is deprecated.
+	Deprecated string `json:"deprecated,omitempty"`
+	// DeprecatedAliases are the Aliases which are deprecated.
+	DeprecatedAliases []string `json:"deprecated_aliases,omitempty"`
+}
+
+// NewSchema returns a Schema describing cmd and all of its descendants.
+func NewSchema(cmd Command) Schema {
+	ret := Schema{Version: SchemaVersion}
+	Walk(cmd, func(path []string, c Command) error {
+		ret.Commands = append(ret.Commands, newCommandSchema(path, c))
+		return nil
+	})
+	return ret
+}
+
+// WriteSchema writes the Schema for cmd to w as indented JSON.
+func WriteSchema(w io.Writer, cmd Command) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(NewSchema(cmd))
+}
+
+// WithSchemaCommand returns a copy of the directory command dir, with a hidden __schema child
+// which writes the Schema for dir to StdOut.
+func WithSchemaCommand(dir Command) Command {
+	dir.Children = maps.Clone(dir.Children)
+	dir.Children["__schema"] = Command{
+		Metadata: Metadata{
+			Short:  "writes a JSON description of the command tree",
+			Hidden: true,
+		},
+		F: func(c Context) error {
+			return WriteSchema(c.StdOut, dir)
+		},
+	}
+	return dir
+}
+
+func newCommandSchema(path []string, c Command) CommandSchema {
+	ret := CommandSchema{
+		Path:     append([]string{}, path...),
+		Short:    c.Short,
+		Tags:     c.Tags,
+		Hidden:   c.Hidden,
+		Children: sortedKeys(c.Children),
+	}
+	if c.Deprecated != nil {
+		ret.Deprecated = c.Deprecated.note()
+	}
+	for i, pos := range c.Pos {
+		ps := newParamSchema(pos)
+		ps.Name = positionalName(pos, i)
+		ret.Positional = append(ret.Positional, ps)
+	}
+	names := makeParamNames(c.canonicalFlags(), nil)
+	aliases := make(map[Parameter][]string)
+	for _, k := range sortedKeys(c.Flags) {
+		if flag := c.Flags[k]; names[flag] != k {
+			aliases[flag] = append(aliases[flag], k)
+		}
+	}
+	for _, k := range sortedKeys(c.Flags) {
+		flag := c.Flags[k]
+		if names[flag] != k {
+			continue
+		}
+		ps := newParamSchema(flag)
+		ps.Name = k
+		ps.Aliases = aliases[flag]
+		for _, alias := range ps.Aliases {
+			if _, deprecated := c.DeprecatedFlags[alias]; deprecated {
+				ps.DeprecatedAliases = append(ps.DeprecatedAliases, alias)
+			}
+		}
+		ret.Flags = append(ret.Flags, ps)
+	}
+	return ret
+}
+
+func newParamSchema(p Parameter) ParamSchema {
+	info := p.ParamInfo()
+	ret := ParamSchema{
+		Doc:       info.ShortDoc,
+		Type:      info.Type,
+		MinCount:  info.MinCount,
+		NoValue:   info.NoValue,
+		Choices:   info.Choices,
+		Env:       info.Env,
+		ConfigKey: info.ConfigKey,
+		Secret:    info.Secret,
+		Hidden:    info.Hidden,
+	}
+	if info.Deprecated != nil {
+		ret.Deprecated = info.Deprecated.note()
+	}
+	if info.MaxCount < math.MaxInt {
+		ret.MaxCount = Ptr(info.MaxCount)
+	}
+	if !info.Secret {
+		ret.Defaults = formatDefaults(p, info.Defaults)
+	}
+	return ret
+}
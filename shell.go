@@ -0,0 +1,175 @@
+package star
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/maps"
+)
+
+// WithShell returns a copy of the directory command dir, with a shell child.
+//
+// The shell reads lines from StdIn, splits them into arguments with SplitArgs, and runs them with dir.
+// The Env and go context.Context are shared between all of the commands run by the shell.
+// Commands are run with the name dir was called as, rather than "shell".
+// Errors from commands are written to StdErr, and do not end the shell.
+//
+// In addition to the children of dir, the shell understands:
+//   - exit: ends the shell.
+//   - history: lists the previous lines, by number.
+//   - !N: runs line N from the history again.
+//   - complete ARGS...: lists completions for the last argument.
+func WithShell(dir Command) Command {
+	dir = recordCalledAs(dir)
+	dir.Children = maps.Clone(dir.Children)
+	dir.Children["shell"] = Command{
+		Metadata: Metadata{Short: "runs commands read from stdin, one per line"},
+		F: func(c Context) error {
+			return runShell(c, dir)
+		},
+		helper: true,
+	}
+	return dir
+}
+
+func runShell(c Context, dir Command) error {
+	var history []string
+	scanner := bufio.NewScanner(c.StdIn)
+	for {
+		fmt.Fprint(c.StdErr, "> ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		if err := c.Err(); err != nil {
+			return err
+		}
+		line := scanner.Text()
+		if n, yes := strings.CutPrefix(strings.TrimSpace(line), "!"); yes {
+			i, err := strconv.Atoi(n)
+			if err != nil || i < 1 || i > len(history) {
+				fmt.Fprintf(c.StdErr, "no history entry %q\n", n)
+				continue
+			}
+			line = history[i-1]
+		}
+		args, err := SplitArgs(line)
+		if err != nil {
+			fmt.Fprintf(c.StdErr, "%v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		history = append(history, line)
+
+		switch args[0] {
+		case "exit":
+			return nil
+		case "history":
+			for i, h := range history {
+				c.Printf("%4d  %s\n", i+1, h)
+			}
+		case "complete":
+			args = args[1:]
+			if strings.HasSuffix(line, " ") || len(args) == 0 {
+				args = append(args, "")
+			}
+			for _, cand := range Complete(dir, args) {
+				c.Printf("%s\n", cand)
+			}
+		default:
+			if err := Run(c.Context, dir, c.Env, parentCalledAs(c), args, strings.NewReader(""), c.StdOut, c.StdErr); err != nil {
+				fmt.Fprintf(c.StdErr, "%v\n", err)
+			}
+		}
+	}
+}
+
+// SplitArgs splits a line into arguments the way a shell would.
+// Arguments are separated by whitespace.
+// Single quotes preserve everything between them.
+// Double quotes preserve everything between them, except backslash escapes.
+// Outside of quotes, a backslash escapes the next character.
+func SplitArgs(line string) ([]string, error) {
+	return SplitArgsExpand(line, nil)
+}
+
+// SplitArgsExpand is like SplitArgs, except $VAR and ${VAR} outside of single quotes are replaced with expand(VAR).
+// A replaced value is always part of a single argument, even if it contains spaces.
+// If expand is nil, then $ is not special.
+func SplitArgsExpand(line string, expand func(string) string) ([]string, error) {
+	var ret []string
+	var sb strings.Builder
+	var inArg bool
+	var quote rune
+	var escaped bool
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case escaped:
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case r == '$' && quote != '\'' && expand != nil:
+			name, n := varName(rs[i+1:])
+			if n == 0 {
+				sb.WriteRune(r)
+			} else {
+				sb.WriteString(expand(name))
+				i += n
+			}
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				ret = append(ret, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			sb.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		ret = append(ret, sb.String())
+	}
+	return ret, nil
+}
+
+// varName returns the name of the variable at the start of rs, which follows a $, and the number of runes it takes up.
+// n is 0 if there is no variable name.
+func varName(rs []rune) (name string, n int) {
+	if len(rs) > 0 && rs[0] == '{' {
+		for i := 1; i < len(rs); i++ {
+			if rs[i] == '}' {
+				return string(rs[1:i]), i + 1
+			}
+		}
+		return "", 0
+	}
+	for n < len(rs) && (rs[n] == '_' || unicode.IsLetter(rs[n]) || unicode.IsDigit(rs[n])) {
+		n++
+	}
+	return string(rs[:n]), n
+}
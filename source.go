@@ -0,0 +1,176 @@
+package star
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// SourceKind is the kind of place a value can come from.
+type SourceKind int
+
+const (
+	SourceFlag = SourceKind(iota)
+	SourcePositional
+	SourceEnv
+	SourceConfig
+	SourceDefault
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case SourceFlag:
+		return "flag"
+	case SourcePositional:
+		return "positional"
+	case SourceEnv:
+		return "env"
+	case SourceConfig:
+		return "config"
+	case SourceDefault:
+		return "default"
+	default:
+		return fmt.Sprintf("SourceKind(%d)", int(k))
+	}
+}
+
+// Source is where a parameter's value came from.
+type Source struct {
+	Kind SourceKind
+	// Name is the flag, positional parameter, environment variable or config key which provided the value.
+	Name string
+	// File and Line are set for values from a config file.
+	File string
+	Line int
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceConfig:
+		return fmt.Sprintf("config %s:%d (%s)", s.File, s.Line, s.Name)
+	case SourceDefault:
+		return "default"
+	default:
+		return fmt.Sprintf("%v %s", s.Kind, s.Name)
+	}
+}
+
+// SourcesOf returns where each of the values for p came from.
+func (c Context) SourcesOf(p Parameter) []Source {
+	panicIfNotHas(p, c)
+	return c.Sources[p]
+}
+
+// valueSet collects parsed values, and where each one came from.
+// srcs can be nil, if the sources are not needed.
+type valueSet struct {
+	vals map[Parameter][]any
+	srcs map[Parameter][]Source
+	// infos caches the ParamInfo for each parameter, so it is only built once while parsing.
+	infos map[Parameter]ParamInfo
+}
+
+func newValueSet(vals map[Parameter][]any, srcs map[Parameter][]Source) valueSet {
+	return valueSet{vals: vals, srcs: srcs, infos: make(map[Parameter]ParamInfo)}
+}
+
+// info returns p.ParamInfo(), calling it only the first time.
+func (vs valueSet) info(p Parameter) ParamInfo {
+	if vs.infos == nil {
+		return p.ParamInfo()
+	}
+	info, exists := vs.infos[p]
+	if !exists {
+		info = p.ParamInfo()
+		vs.infos[p] = info
+	}
+	return info
+}
+
+func (vs valueSet) add(p Parameter, v any, src Source) {
+	vs.vals[p] = append(vs.vals[p], v)
+	if vs.srcs != nil {
+		vs.srcs[p] = append(vs.srcs[p], src)
+	}
+}
+
+// WriteValues writes the effective value of each of the command's parameters, and where it came from.
+// Values of secret parameters are redacted.
+func WriteValues(w io.Writer, c Context) error {
+	names := displayNames(c.self.canonicalFlags(), c.self.Pos)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, param := range allParams(c.self.Flags, c.self.Pos) {
+		vals, srcs := c.Values[param], c.Sources[param]
+		if len(vals) == 0 {
+			fmt.Fprintf(tw, "%s\t(not set)\t\n", names[param])
+			continue
+		}
+		if _, isCounter := param.(*Counter); isCounter {
+			// a counter is shown as one row with the count.
+			var kinds []string
+			for _, src := range srcs {
+				if !slices.Contains(kinds, src.String()) {
+					kinds = append(kinds, src.String())
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", names[param], len(vals), strings.Join(kinds, ", "))
+			continue
+		}
+		for i := range vals {
+			val := formatValue(param, vals[i])
+			if param.ParamInfo().Secret {
+				val = "<redacted>"
+			}
+			var src string
+			if i < len(srcs) {
+				src = srcs[i].String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", names[param], val, src)
+		}
+	}
+	return tw.Flush()
+}
+
+type printConfigKey struct{}
+
+// WithPrintConfig returns a context.Context which enables the --print-config flag for commands run with it.
+// When --print-config is passed, the command's values are written to StdOut with WriteValues
+// instead of calling the command's function.
+func WithPrintConfig(ctx context.Context) context.Context {
+	return context.WithValue(ctx, printConfigKey{}, true)
+}
+
+func printConfigEnabled(ctx context.Context) bool {
+	yes, _ := ctx.Value(printConfigKey{}).(bool)
+	return yes
+}
+
+// cutPrintConfig removes --print-config from args, and returns true if it was there.
+// The values of flags are skipped, in the same way as parseFlags,
+// so --print-config can be the value of a flag.
+func cutPrintConfig(flags map[string]Flag, args []string) (bool, []string) {
+	if _, exists := flags["print-config"]; exists {
+		return false, args
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == flagPrefix+"print-config" {
+			return true, append(args[:i:i], args[i+1:]...)
+		}
+		if k, yes := strings.CutPrefix(arg, flagPrefix); yes {
+			if flag, exists := flags[k]; exists && takesValue(flag) {
+				i++
+			}
+			continue
+		}
+		if isShortFlag(flags, arg) {
+			if flag := flags[arg[len(arg)-1:]]; takesValue(flag) {
+				i++
+			}
+		}
+	}
+	return false, args
+}
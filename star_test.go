@@ -1,10 +1,19 @@
 package star
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -102,6 +111,9 @@ func TestParsePos(t *testing.T) {
 	mustHave2 := &Required[string]{PosName: "must-have", Parse: ParseString}
 	xs2 := &Repeated[string]{PosName: "xs", Parse: ParseString}
 	optional := &Optional[string]{PosName: "optional", Parse: ParseString}
+	srcs := &Repeated[string]{PosName: "src", Parse: ParseString, Min: 1}
+	dst := &Required[string]{PosName: "dst", Parse: ParseString}
+	bounded := &Repeated[string]{PosName: "bounded", Parse: ParseString, Min: 1, Max: 2}
 	tcs := []testCase{
 		{
 			Args: []string{"1", "a", "b", "c"},
@@ -136,6 +148,36 @@ func TestParsePos(t *testing.T) {
 			Extra:  []string{},
 			Values: map[Parameter][]any{},
 		},
+		{
+			Args: []string{"a", "b", "c"},
+			Pos:  []Positional{srcs, dst},
+
+			Extra: nil,
+			Values: map[Parameter][]any{
+				srcs: {"a", "b"},
+				dst:  {"c"},
+			},
+		},
+		{
+			Args: []string{"a", "b", "c", "d"},
+			Pos:  []Positional{bounded, xs},
+
+			Extra: nil,
+			Values: map[Parameter][]any{
+				bounded: {"a", "b"},
+				xs:      {"c", "d"},
+			},
+		},
+		{
+			// unknown flags, and their values, are skipped and kept in Extra.
+			Args: []string{"--unknown", "x", "1"},
+			Pos:  []Positional{optional},
+
+			Extra: []string{"--unknown", "x"},
+			Values: map[Parameter][]any{
+				optional: {"1"},
+			},
+		},
 	}
 	for i, tc := range tcs {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
@@ -147,3 +189,1249 @@ func TestParsePos(t *testing.T) {
 		})
 	}
 }
+
+func TestChoices(t *testing.T) {
+	format := &Optional[int]{
+		Choices:  Choices[int]{"json": 1, "table": 2},
+		ShortDoc: "output format",
+	}
+	cmd := Command{
+		Flags: map[string]Flag{"format": format},
+		F:     func(c Context) error { return nil },
+	}
+
+	dst := make(map[Parameter][]any)
+	_, err := ParseFlags(dst, cmd.Flags, []string{"--format", "table"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{2}, dst[format])
+
+	_, err = ParseFlags(dst, cmd.Flags, []string{"--format", "yaml"})
+	require.ErrorContains(t, err, "must be one of: json, table")
+
+	dst = make(map[Parameter][]any)
+	_, err = ParseFlags(dst, cmd.Flags, []string{"--format=json"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{1}, dst[format])
+
+	assert.Contains(t, cmd.Doc("test"), "output format (one of: json, table)")
+	assert.Equal(t, []string{"json"}, Complete(cmd, []string{"--format", "j"}))
+	assert.Equal(t, []string{"--format"}, Complete(cmd, []string{"--f"}))
+	assert.Equal(t, []string{"--format=json", "--format=table"}, Complete(cmd, []string{"--format="}))
+	assert.Equal(t, []string{"--format=table"}, Complete(cmd, []string{"--format=t"}))
+	assert.Empty(t, Complete(cmd, []string{"--nope="}))
+}
+
+func TestRules(t *testing.T) {
+	asJSON := &Optional[string]{Parse: ParseString}
+	asTable := &Optional[string]{Parse: ParseString}
+	key := &Optional[string]{Parse: ParseString}
+	cert := &Optional[string]{Parse: ParseString}
+	level := &Optional[int]{Parse: strconv.Atoi, Checks: []Check[int]{InRange(1, 9)}}
+	cmd := Command{
+		Flags: map[string]Flag{
+			"json":  asJSON,
+			"table": asTable,
+			"key":   key,
+			"cert":  cert,
+			"level": level,
+		},
+		Rules: []Rule{
+			MutuallyExclusive(asJSON, asTable),
+			Requires(key, cert),
+			AtLeastOneOf(asJSON, asTable),
+		},
+		F: func(c Context) error { return nil },
+	}
+	run := func(args ...string) error {
+		return Run(context.Background(), cmd, nil, "test", args, nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, run("--json", "1"))
+	require.ErrorContains(t, run("--json", "1", "--table", "1"), "--json, --table cannot be used together")
+	require.ErrorContains(t, run("--table", "1", "--key", "k"), "--key requires --cert")
+	require.ErrorContains(t, run(), "at least one of --json, --table")
+	require.ErrorContains(t, run("--json", "1", "--level", "10"), "10 is not in [1, 9]")
+
+	doc := cmd.Doc("test")
+	assert.Contains(t, doc, "--key requires --cert")
+	assert.Contains(t, doc, "(in [1, 9])")
+
+	// defaults do not count as provided
+	asTable.Default = Ptr("t")
+	require.NoError(t, run("--json", "1"))
+	require.ErrorContains(t, run(), "at least one of --json, --table")
+	key.Default = Ptr("k")
+	require.NoError(t, run("--json", "1"))
+	asTable.Default, key.Default = nil, nil
+
+	// a parameter with several flag names is named once, with one prefix
+	cmd.Flags["k"] = key
+	require.ErrorContains(t, run("--table", "1", "-k", "k"), "parameter --key requires --cert")
+	assert.Contains(t, cmd.Doc("test"), "  --key requires --cert\n")
+}
+
+func TestUsageError(t *testing.T) {
+	n := &Required[int]{PosName: "n", Parse: strconv.Atoi}
+	level := &Optional[int]{Parse: strconv.Atoi, Env: "LEVEL"}
+	tags := &Map[string, string]{ParseKey: ParseString, ParseValue: ParseString}
+	a := &Optional[string]{Parse: ParseString}
+	b := &Optional[string]{Parse: ParseString}
+	root := NewDir(Metadata{}, map[string]Command{
+		"cmd": {
+			Pos:   []Positional{n},
+			Flags: map[string]Flag{"level": level, "tag": tags, "a": a, "b": b},
+			Rules: []Rule{MutuallyExclusive(a, b)},
+			F: func(c Context) error {
+				return fmt.Errorf("failed")
+			},
+		},
+	})
+	run := func(env map[string]string, args ...string) error {
+		return Run(context.Background(), root, env, "test", args, nil, io.Discard, io.Discard)
+	}
+	for _, tc := range []struct {
+		env  map[string]string
+		args []string
+		kind UsageErrorKind
+	}{
+		{args: []string{"cmd", "1", "--level", "x"}, kind: UsageFlag},
+		{args: []string{"cmd", "x"}, kind: UsagePositional},
+		{args: []string{"cmd", "1"}, env: map[string]string{"LEVEL": "x"}, kind: UsageLayer},
+		{args: []string{"cmd"}, kind: UsageMissing},
+		{args: []string{"cmd", "1", "--level", "1", "--level", "2"}, kind: UsageTooMany},
+		{args: []string{"cmd", "1", "--tag", "k=1", "--tag", "k=2"}, kind: UsageInvalid},
+		{args: []string{"cmd", "1", "-a", "1", "-b", "2"}, kind: UsageRule},
+		{args: []string{"nope"}, kind: UsageUnknownCommand},
+	} {
+		err := run(tc.env, tc.args...)
+		require.Error(t, err, "%v", tc.args)
+		assert.True(t, IsUsageError(err, tc.kind), "%v: %v", tc.args, err)
+		assert.Equal(t, 2, ExitCode(err))
+	}
+	err := run(nil, "cmd", "1")
+	require.Error(t, err)
+	var uerr *UsageError
+	assert.False(t, errors.As(err, &uerr))
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Equal(t, 0, ExitCode(nil))
+}
+
+func TestRepeatedBounds(t *testing.T) {
+	files := &Repeated[string]{PosName: "file", Parse: ParseString, Min: 2, Max: 4}
+	cmd := Command{
+		Pos: []Positional{files},
+		F:   func(c Context) error { return nil },
+	}
+	run := func(args ...string) error {
+		return Run(context.Background(), cmd, nil, "test", args, nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, run("a", "b"))
+	require.Error(t, run("a"))
+
+	dst := make(map[Parameter][]any)
+	tags := &Repeated[string]{Parse: ParseString, Max: 1}
+	_, err := ParseFlags(dst, map[string]Flag{"tag": tags}, []string{"--tag", "a", "--tag", "b"})
+	require.ErrorContains(t, err, "provided too many times")
+	assert.Contains(t, cmd.Doc("test"), "test <file>{2,4}")
+
+	files.Max = 0
+	assert.Contains(t, cmd.Doc("test"), "test <file>...")
+}
+
+func TestListAndMap(t *testing.T) {
+	tags := &List[string]{Parse: ParseString, ShortDoc: "tags to apply"}
+	labels := &Map[string, int]{ParseKey: ParseString, ParseValue: strconv.Atoi}
+	var gotTags []string
+	var gotLabels map[string]int
+	cmd := Command{
+		Flags: map[string]Flag{"tags": tags, "label": labels},
+		F: func(c Context) error {
+			gotTags = tags.Load(c)
+			gotLabels = labels.Load(c)
+			return nil
+		},
+	}
+	run := func(args ...string) error {
+		return Run(context.Background(), cmd, nil, "test", args, nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, run("--tags", `a,"b,c"`, "--tags", "d", "--label", "x=1", "--label", "y=2"))
+	assert.Equal(t, []string{"a", "b,c", "d"}, gotTags)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, gotLabels)
+
+	require.ErrorContains(t, run("--label", "x=1", "--label", "x=2"), "duplicate key x")
+	require.ErrorContains(t, run("--label", "x"), "not of the form key=value")
+
+	require.ErrorContains(t, run("--tags", "a\nb"), "newlines must be quoted")
+	require.NoError(t, run("--tags", "\"a\nb\",c"))
+	assert.Equal(t, []string{"a\nb", "c"}, gotTags)
+
+	doc := cmd.Doc("test")
+	assert.Contains(t, doc, `tags to apply (list, separated by ',')`)
+	assert.Contains(t, doc, "(key=value, repeated)")
+	assert.Equal(t, "[labels:key=value ...]", labels.UsagePositional("labels"))
+}
+
+func TestCounter(t *testing.T) {
+	verbose := &Counter{ShortDoc: "increase verbosity"}
+	out := &Optional[string]{Parse: ParseString}
+	flags := map[string]Flag{
+		"verbose": verbose,
+		"v":       verbose,
+		"o":       out,
+	}
+	tcs := []struct {
+		Args  []string
+		Count int
+		Extra []string
+	}{
+		{Args: []string{"-v"}, Count: 1},
+		{Args: []string{"-vvv", "x"}, Count: 3, Extra: []string{"x"}},
+		{Args: []string{"--verbose", "x", "--verbose"}, Count: 2, Extra: []string{"x"}},
+		{Args: []string{"-vvo", "out.txt", "x"}, Count: 2, Extra: []string{"x"}},
+		{Args: []string{"-vx"}, Count: 0, Extra: []string{"-vx"}},
+		{Args: []string{"-v", "-1"}, Count: 1, Extra: []string{"-1"}},
+	}
+	for i, tc := range tcs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			dst := make(map[Parameter][]any)
+			extra, err := ParseFlags(dst, flags, tc.Args)
+			require.NoError(t, err)
+			assert.Len(t, dst[verbose], tc.Count)
+			assert.Equal(t, tc.Extra, extra)
+		})
+	}
+	doc := Command{Flags: flags}.Doc("test")
+	assert.Contains(t, doc, "increase verbosity (count)")
+}
+
+func TestSplitChildName(t *testing.T) {
+	noop := func(c Context) error { return nil }
+	dir := Command{
+		Flags:    map[string]Flag{"level": &Optional[int]{Parse: strconv.Atoi}, "quiet": &Counter{}},
+		Children: map[string]Command{"get": {F: noop}, "put": {F: noop}},
+	}
+	for i, tc := range []struct {
+		Args  []string
+		Child string
+		Rest  []string
+	}{
+		{Args: []string{"get", "x"}, Child: "get", Rest: []string{"x"}},
+		{Args: []string{"--verbose", "get", "x"}, Child: "get", Rest: []string{"--verbose", "x"}},
+		{Args: []string{"--name", "x", "get"}, Child: "get", Rest: []string{"--name", "x"}},
+		{Args: []string{"--level", "put", "get"}, Child: "get", Rest: []string{"--level", "put"}},
+		{Args: []string{"--quiet", "put"}, Child: "put", Rest: []string{"--quiet"}},
+		{Args: []string{"--name=x", "-v", "put"}, Child: "put", Rest: []string{"--name=x", "-v"}},
+		{Args: []string{"--verbose"}},
+	} {
+		child, rest := splitChildName(dir, slices.Clone(tc.Args))
+		assert.Equal(t, tc.Child, child, "case %d", i)
+		assert.Equal(t, tc.Rest, rest, "case %d", i)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	port := &Required[int]{
+		Parse:     strconv.Atoi,
+		Env:       "PORT",
+		ConfigKey: "port",
+		Default:   Ptr(80),
+	}
+	var got int
+	serve := Command{
+		Flags: map[string]Flag{"port": port},
+		F: func(c Context) error {
+			got = port.Load(c)
+			return nil
+		},
+	}
+	root := NewDir(Metadata{}, map[string]Command{"serve": serve})
+	conf, err := ParseConfig("test.yaml", []byte("serve:\n  port: 8080\n"))
+	require.NoError(t, err)
+
+	run := func(ctx context.Context, env map[string]string, args ...string) error {
+		return Run(ctx, root, env, "test", args, nil, io.Discard, io.Discard)
+	}
+	ctx := context.Background()
+	require.NoError(t, run(ctx, nil, "serve"))
+	assert.Equal(t, 80, got)
+
+	ctx = WithConfig(ctx, conf)
+	require.NoError(t, run(ctx, nil, "serve"))
+	assert.Equal(t, 8080, got)
+
+	require.NoError(t, run(ctx, map[string]string{"PORT": "9090"}, "serve"))
+	assert.Equal(t, 9090, got)
+
+	require.NoError(t, run(ctx, map[string]string{"PORT": "9090"}, "serve", "--port", "1"))
+	assert.Equal(t, 1, got)
+
+	conf, err = ParseConfig("test.yaml", []byte("serve:\n  port: abc\n"))
+	require.NoError(t, err)
+	require.ErrorContains(t, run(WithConfig(context.Background(), conf), nil, "serve"), "test.yaml:2:")
+
+	conf, err = ParseConfig("test.toml", []byte("# comment\n[serve]\nport = 7070\n"))
+	require.NoError(t, err)
+	require.NoError(t, run(WithConfig(ctx, conf), nil, "serve"))
+	assert.Equal(t, 7070, got)
+	conf, err = ParseConfig("test.toml", []byte("[serve]\n\nport = \"abc\"\n"))
+	require.NoError(t, err)
+	require.ErrorContains(t, run(WithConfig(context.Background(), conf), nil, "serve"), "test.toml:3:")
+	_, err = ParseConfig("test.toml", []byte("port = "))
+	require.ErrorContains(t, err, "test.toml")
+
+	// lists, maps and counters can also come from each layer
+	tags := &List[string]{Parse: ParseString, Env: "TAGS", ConfigKey: "tags", Default: []string{"x"}}
+	labels := &Map[string, string]{ParseKey: ParseString, ParseValue: ParseString, Env: "LABEL", ConfigKey: "labels", Default: map[string]string{"b": "2", "a": "1"}}
+	verbose := &Counter{Env: "VERBOSE", ConfigKey: "verbose", Default: 1}
+	var gotTags []string
+	var gotLabels map[string]string
+	var gotVerbose int
+	cmd := Command{
+		Flags: map[string]Flag{"tags": tags, "label": labels, "v": verbose},
+		F: func(c Context) error {
+			gotTags, gotLabels, gotVerbose = tags.Load(c), labels.Load(c), verbose.Load(c)
+			return nil
+		},
+	}
+	runCmd := func(ctx context.Context, env map[string]string) {
+		require.NoError(t, Run(ctx, cmd, env, "test", nil, nil, io.Discard, io.Discard))
+	}
+	runCmd(context.Background(), nil)
+	assert.Equal(t, []string{"x"}, gotTags)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, gotLabels)
+	assert.Equal(t, 1, gotVerbose)
+	assert.Contains(t, cmd.Doc("test"), "default: a=1, b=2")
+	assert.Contains(t, cmd.Doc("test"), "env: VERBOSE; config: verbose; default: 1")
+
+	runCmd(context.Background(), map[string]string{"TAGS": "y,z", "LABEL": "c=3", "VERBOSE": "3"})
+	assert.Equal(t, []string{"y", "z"}, gotTags)
+	assert.Equal(t, map[string]string{"c": "3"}, gotLabels)
+	assert.Equal(t, 3, gotVerbose)
+
+	conf, err = ParseConfig("test.yaml", []byte("tags: [p, q]\nlabels: [d=4]\nverbose: false\n"))
+	require.NoError(t, err)
+	runCmd(WithConfig(context.Background(), conf), nil)
+	assert.Equal(t, []string{"p", "q"}, gotTags)
+	assert.Equal(t, map[string]string{"d": "4"}, gotLabels)
+	assert.Equal(t, 0, gotVerbose)
+
+	require.Error(t, Run(context.Background(), cmd, map[string]string{"VERBOSE": "lots"}, "test", nil, nil, io.Discard, io.Discard))
+
+	dir := t.TempDir()
+	assert.Equal(t, filepath.Join(dir, "config.yaml"), findConfig(dir))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"), nil, 0o644))
+	assert.Equal(t, filepath.Join(dir, "config.toml"), findConfig(dir))
+}
+
+func TestCutConfigFlag(t *testing.T) {
+	level := &Optional[string]{Parse: ParseString}
+	noop := func(c Context) error { return nil }
+	root := Command{
+		Flags: map[string]Flag{"level": level},
+		Children: map[string]Command{
+			"child": {Flags: map[string]Flag{"config": &Optional[string]{Parse: ParseString}}, F: noop},
+		},
+	}
+	for i, tc := range []struct {
+		Args []string
+		Path string
+		Rest []string
+	}{
+		{Args: []string{"--config", "a.yaml", "child"}, Path: "a.yaml", Rest: []string{"child"}},
+		{Args: []string{"--level", "x", "--config=a.yaml", "child"}, Path: "a.yaml", Rest: []string{"--level", "x", "child"}},
+		{Args: []string{"--level", "--config", "child", "--config", "b.yaml"}, Rest: []string{"--level", "--config", "child", "--config", "b.yaml"}},
+		{Args: []string{"child", "--config", "b.yaml"}, Rest: []string{"child", "--config", "b.yaml"}},
+	} {
+		p, rest := cutConfigFlag(root, slices.Clone(tc.Args))
+		assert.Equal(t, tc.Path, p, "case %d", i)
+		assert.Equal(t, tc.Rest, rest, "case %d", i)
+	}
+	p, rest := cutConfigFlag(root.Children["child"], []string{"--config", "c.yaml"})
+	assert.Equal(t, "", p)
+	assert.Equal(t, []string{"--config", "c.yaml"}, rest)
+}
+
+func TestSources(t *testing.T) {
+	name := &Required[string]{Parse: ParseString}
+	token := &Required[string]{Parse: ParseString, Env: "TOKEN", Secret: true}
+	level := &Optional[int]{Parse: strconv.Atoi, Default: Ptr(3)}
+	verbose := &Counter{}
+	labels := &Map[string, string]{ParseKey: ParseString, ParseValue: ParseString}
+	var srcs []Source
+	var args []string
+	cmd := Command{
+		Flags: map[string]Flag{"name": name, "token": token, "level": level, "v": verbose, "label": labels},
+		F: func(c Context) error {
+			srcs = append(c.SourcesOf(name), c.SourcesOf(token)...)
+			srcs = append(srcs, c.SourcesOf(level)...)
+			var err error
+			args, err = ContextArgs(c)
+			return err
+		},
+	}
+	env := map[string]string{"TOKEN": "hunter2"}
+	require.NoError(t, Run(context.Background(), cmd, env, "test", []string{"--name", "abc", "--token", "x"}, nil, io.Discard, io.Discard))
+	// the secret, and the default, are not included in the arguments.
+	assert.Equal(t, []string{"--name", "abc"}, args)
+	require.NoError(t, Run(context.Background(), cmd, env, "test", []string{"--name", "abc"}, nil, io.Discard, io.Discard))
+	assert.Equal(t, []Source{
+		{Kind: SourceFlag, Name: "--name"},
+		{Kind: SourceEnv, Name: "TOKEN"},
+		{Kind: SourceDefault},
+	}, srcs)
+
+	var out bytes.Buffer
+	ctx := WithPrintConfig(context.Background())
+	require.NoError(t, Run(ctx, cmd, env, "test", []string{"--name", "abc", "--print-config"}, nil, &out, io.Discard))
+	assert.Contains(t, out.String(), "flag --name")
+	assert.Contains(t, out.String(), "<redacted>")
+	assert.NotContains(t, out.String(), "hunter2")
+
+	// values are written as arguments, and a counter is written once with its count.
+	out.Reset()
+	require.NoError(t, Run(ctx, cmd, env, "test", []string{"--name", "abc", "-vv", "--label", "a=b", "--print-config"}, nil, &out, io.Discard))
+	assert.Contains(t, out.String(), "--label  a=b")
+	assert.Regexp(t, `(?m)^-v +2 +flag -v$`, out.String())
+
+	// --print-config as the value of a flag is not the --print-config flag.
+	out.Reset()
+	require.NoError(t, Run(ctx, cmd, env, "test", []string{"--name", "--print-config"}, nil, &out, io.Discard))
+	assert.Empty(t, out.String())
+}
+
+func TestNewCommand(t *testing.T) {
+	type Args struct {
+		Src     string        `pos:"0" doc:"the source"`
+		Dsts    []string      `pos:"1"`
+		Timeout time.Duration `flag:"timeout" default:"1s"`
+		Retries *int          `flag:"retries" env:"RETRIES"`
+		Force   bool          `flag:"force"`
+
+		ignored string
+	}
+	var got Args
+	cmd := NewCommand(Metadata{Short: "copy"}, func(c Context, args Args) error {
+		got = args
+		return nil
+	})
+	run := func(env map[string]string, args ...string) error {
+		return Run(context.Background(), cmd, env, "test", args, nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, run(nil, "a", "b", "c", "--force"))
+	assert.Equal(t, Args{Src: "a", Dsts: []string{"b", "c"}, Timeout: time.Second, Force: true}, got)
+
+	require.NoError(t, run(map[string]string{"RETRIES": "3"}, "a", "--timeout", "1m"))
+	assert.Equal(t, Args{Src: "a", Timeout: time.Minute, Retries: Ptr(3)}, got)
+
+	require.Error(t, run(nil))
+	assert.Contains(t, cmd.Doc("test"), "test <src> [dsts ...]")
+	assert.Contains(t, cmd.Doc("test"), "(switch)")
+	require.ErrorContains(t, run(nil, "a", "--force", "--force"), "multiple values")
+
+	assert.Panics(t, func() {
+		type Bad struct {
+			Force bool `flag:"force" env:"FORCE"`
+		}
+		NewCommand(Metadata{}, func(c Context, args Bad) error { return nil })
+	})
+	for in, out := range map[string]string{
+		"OutputDir": "output-dir",
+		"HTTPAddr":  "http-addr",
+		"UserID":    "user-id",
+		"ID":        "id",
+		"Src":       "src",
+		"A":         "a",
+	} {
+		assert.Equal(t, out, kebabCase(in), in)
+	}
+
+	schema := NewSchema(cmd).Commands[0]
+	assert.Equal(t, "string", schema.Positional[0].Type)
+	assert.Equal(t, "string", schema.Positional[1].Type)
+	assert.Equal(t, "int", schema.Flags[1].Type)
+	assert.Equal(t, "time.Duration", schema.Flags[2].Type)
+	assert.Equal(t, "bool", schema.Flags[0].Type)
+}
+
+// triState is a custom parameter kind defined outside of the built-in kinds.
+type triState struct {
+	ShortDoc string
+}
+
+func (p *triState) Load(c Context) *bool {
+	vals := c.ValuesOf(p)
+	if len(vals) == 0 {
+		return nil
+	}
+	return Ptr(vals[0].(bool))
+}
+
+func (p *triState) ParseArg(x string) (any, error) {
+	return strconv.ParseBool(x)
+}
+
+func (p *triState) UsageFlag(name string) string {
+	return "(true|false)"
+}
+
+func (p *triState) ParamInfo() ParamInfo {
+	return ParamInfo{ShortDoc: p.ShortDoc, MaxCount: 1, Choices: []string{"false", "true"}}
+}
+
+func TestCustomParameter(t *testing.T) {
+	color := &triState{ShortDoc: "use color"}
+	var got *bool
+	cmd := Command{
+		Flags: map[string]Flag{"color": color},
+		F: func(c Context) error {
+			got = color.Load(c)
+			return nil
+		},
+	}
+	run := func(args ...string) error {
+		return Run(context.Background(), cmd, nil, "test", args, nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, run())
+	assert.Nil(t, got)
+	require.NoError(t, run("--color", "false"))
+	assert.Equal(t, Ptr(false), got)
+	require.ErrorContains(t, run("--color", "true", "--color", "true"), "multiple values")
+
+	assert.Contains(t, cmd.Doc("test"), "use color (one of: false, true) (true|false)")
+	assert.Equal(t, []string{"true"}, Complete(cmd, []string{"--color", "t"}))
+	assert.Panics(t, func() {
+		color.Load(Context{self: &Command{}})
+	})
+}
+
+func TestWalk(t *testing.T) {
+	leaf := Command{Metadata: Metadata{Short: "leaf"}, F: func(c Context) error { return nil }}
+	format := &Optional[string]{Choices: ChoicesOf("json", "text")}
+	withFlag := Command{Flags: map[string]Flag{"format": format}, F: leaf.F}
+	root := NewDir(Metadata{}, map[string]Command{
+		"b": leaf,
+		"a": NewDir(Metadata{}, map[string]Command{
+			"x": leaf,
+			"y": withFlag,
+		}),
+	})
+	var paths [][]string
+	require.NoError(t, Walk(root, func(path []string, c Command) error {
+		paths = append(paths, path)
+		return nil
+	}))
+	assert.Equal(t, [][]string{nil, {"a"}, {"a", "x"}, {"a", "y"}, {"b"}}, paths)
+
+	paths = nil
+	require.NoError(t, Walk(root, func(path []string, c Command) error {
+		paths = append(paths, path)
+		if len(path) > 0 && path[0] == "a" {
+			return SkipChildren
+		}
+		return nil
+	}))
+	assert.Equal(t, [][]string{nil, {"a"}, {"b"}}, paths)
+
+	c, ok := Lookup(root, []string{"a", "x"})
+	require.True(t, ok)
+	assert.Equal(t, "leaf", c.Short)
+	_, ok = Lookup(root, []string{"a", "z"})
+	require.False(t, ok)
+
+	assert.Equal(t, []string{"a", "b"}, Complete(root, []string{""}))
+	assert.Equal(t, []string{"x", "y"}, Complete(root, []string{"a", ""}))
+	assert.Equal(t, []string{"json"}, Complete(root, []string{"a", "y", "--format", "j"}))
+}
+
+func TestSchema(t *testing.T) {
+	verbose := &Counter{ShortDoc: "verbosity"}
+	format := &Optional[string]{Choices: ChoicesOf("json", "text"), Default: Ptr("text"), Env: "FORMAT"}
+	name := &Required[string]{PosName: "name", Parse: ParseString}
+	leaf := Command{
+		Metadata: Metadata{Short: "leaf", Tags: []string{"t1"}},
+		Pos:      []Positional{name},
+		Flags:    map[string]Flag{"verbose": verbose, "v": verbose, "format": format},
+		F:        func(c Context) error { return nil },
+	}
+	root := WithSchemaCommand(NewDir(Metadata{Short: "root"}, map[string]Command{"leaf": leaf}))
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), root, nil, "test", []string{"__schema"}, nil, &out, io.Discard))
+	var schema Schema
+	require.NoError(t, json.Unmarshal(out.Bytes(), &schema))
+	assert.Equal(t, SchemaVersion, schema.Version)
+	require.Len(t, schema.Commands, 3)
+	assert.Equal(t, []string{"__schema", "leaf"}, schema.Commands[0].Children)
+	assert.True(t, schema.Commands[1].Hidden)
+	assert.Equal(t, CommandSchema{
+		Path:  []string{"leaf"},
+		Short: "leaf",
+		Tags:  []string{"t1"},
+		Positional: []ParamSchema{
+			{Name: "name", Type: "string", MinCount: 1, MaxCount: Ptr(1)},
+		},
+		Flags: []ParamSchema{
+			{Name: "format", Type: "string", MaxCount: Ptr(1), Choices: []string{"json", "text"}, Defaults: []string{"text"}, Env: "FORMAT"},
+			{Name: "verbose", Aliases: []string{"v"}, Doc: "verbosity", Type: "int", NoValue: true},
+		},
+	}, schema.Commands[2])
+
+	out.Reset()
+	require.NoError(t, Run(context.Background(), root, nil, "test", nil, nil, &out, io.Discard))
+	assert.NotContains(t, out.String(), "__schema")
+}
+
+func TestSplitArgs(t *testing.T) {
+	tcs := []struct {
+		In  string
+		Out []string
+	}{
+		{In: "", Out: nil},
+		{In: "  a b\tc ", Out: []string{"a", "b", "c"}},
+		{In: `a "b c" 'd "e"'`, Out: []string{"a", "b c", `d "e"`}},
+		{In: `a\ b "c\"d" ''`, Out: []string{"a b", `c"d`, ""}},
+	}
+	for i, tc := range tcs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			out, err := SplitArgs(tc.In)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Out, out)
+		})
+	}
+	_, err := SplitArgs(`a "b`)
+	require.Error(t, err)
+}
+
+func TestSplitArgsExpand(t *testing.T) {
+	env := map[string]string{"A": "x y", "B": "b"}
+	args, err := SplitArgsExpand(`a $A ${B}c '$A' "$A" \$B $`, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "x y", "bc", "$A", "x y", "$B", "$"}, args)
+
+	// SplitArgs does not expand anything
+	args, err = SplitArgs(`$A "${B}"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"$A", "${B}"}, args)
+}
+
+func TestShell(t *testing.T) {
+	name := &Required[string]{PosName: "name", Parse: ParseString}
+	root := WithShell(NewDir(Metadata{}, map[string]Command{
+		"greet": {
+			Pos: []Positional{name},
+			F: func(c Context) error {
+				c.Printf("hello %s\n", name.Load(c))
+				return nil
+			},
+		},
+	}))
+	script := strings.Join([]string{
+		`greet "big world"`,
+		`greet`,
+		`history`,
+		`!1`,
+		`complete gr`,
+		`exit`,
+		`greet never`,
+	}, "\n")
+	var out, errOut bytes.Buffer
+	require.NoError(t, Run(context.Background(), root, nil, "test", []string{"shell"}, strings.NewReader(script), &out, &errOut))
+	assert.Equal(t, "hello big world\n"+
+		"   1  greet \"big world\"\n   2  greet\n   3  history\n"+
+		"hello big world\n"+
+		"greet\n", out.String())
+	assert.Contains(t, errOut.String(), "missing value")
+
+	// lines are run with the name the directory was called as
+	out.Reset()
+	require.NoError(t, Run(context.Background(), root, nil, "test", []string{"shell"}, strings.NewReader("--x\n"), &out, io.Discard))
+	assert.True(t, strings.HasPrefix(out.String(), "test\n\n"), out.String())
+}
+
+func TestBatch(t *testing.T) {
+	name := &Required[string]{PosName: "name", Parse: ParseString}
+	dir := NewDir(Metadata{}, map[string]Command{
+		"slow": {
+			F: func(c Context) error {
+				<-c.Done()
+				return c.Err()
+			},
+		},
+		"greet": {
+			Pos: []Positional{name},
+			F: func(c Context) error {
+				c.Printf("hello %s\n", name.Load(c))
+				return nil
+			},
+		},
+		"fail": {
+			F: func(c Context) error {
+				return fmt.Errorf("failed")
+			},
+		},
+	})
+	dir.Flags = map[string]Flag{"v": &Counter{}}
+	root := WithBatch(dir)
+	script := "greet a\n# comment\n\ngreet 'b c'\nfail\ngreet d\n"
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := Run(context.Background(), root, nil, "test", append([]string{"batch"}, args...), strings.NewReader(script), &out, io.Discard)
+		return out.String(), err
+	}
+
+	out, err := run("--parallel", "4", "--continue-on-error")
+	require.Error(t, err)
+	assert.Equal(t, "1: hello a\n4: hello b c\n5: error (exit 1): failed\n6: hello d\n", out)
+
+	out, err = run()
+	require.Error(t, err)
+	assert.Equal(t, "1: hello a\n4: hello b c\n5: error (exit 1): failed\n", out)
+
+	out, err = run("--output", "jsonl", "--continue-on-error")
+	require.Error(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	var res BatchResult
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &res))
+	assert.Equal(t, BatchResult{Line: 4, Args: []string{"greet", "b c"}, Stdout: "hello b c\n"}, res)
+
+	// defaults are written the way they would be passed
+	assert.Contains(t, root.Children["batch"].Doc("batch"), "one of: jsonl, prefix; default: prefix")
+	for _, ps := range NewSchema(root.Children["batch"]).Commands[0].Flags {
+		if ps.Name == "output" {
+			assert.Equal(t, []string{"prefix"}, ps.Defaults)
+		}
+	}
+
+	// lines are run with the name the directory was called as, and a failed line cancels the others
+	script = "-v\nslow\nfail\ngreet e\n"
+	out, err = run("--parallel", "2")
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(out, "1: test\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\n2: error (exit 1): cancelled because line 3 failed\n3: error (exit 1): failed\n"), out)
+}
+
+func TestBuildArgs(t *testing.T) {
+	src := &Required[string]{PosName: "src", Parse: ParseString}
+	dst := &Optional[string]{PosName: "dst", Parse: ParseString}
+	verbose := &Counter{}
+	tags := &Repeated[string]{Parse: ParseString}
+	cmd := Command{
+		Pos:   []Positional{src, dst},
+		Flags: map[string]Flag{"v": verbose, "verbose": verbose, "tag": tags},
+	}
+	args, err := BuildArgs(cmd, map[string][]string{
+		"src":     {"a"},
+		"dst":     {"b"},
+		"verbose": {"", ""},
+		"tag":     {"x", "y"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--tag", "x", "--tag", "y", "--verbose", "--verbose", "a", "b"}, args)
+
+	_, err = BuildArgs(cmd, map[string][]string{"dst": {"b"}})
+	require.ErrorContains(t, err, `"src" must be provided before "dst"`)
+	_, err = BuildArgs(cmd, map[string][]string{"nope": {"b"}})
+	require.Error(t, err)
+	_, err = BuildArgs(cmd, map[string][]string{"src": {"-v"}})
+	require.ErrorContains(t, err, "would be parsed as a flag")
+	args, err = BuildArgs(cmd, map[string][]string{"src": {"-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-1"}, args)
+}
+
+func TestJSONStrings(t *testing.T) {
+	for in, expect := range map[string][]string{
+		`"a"`:      {"a"},
+		`1.5`:      {"1.5"},
+		`["a", 2]`: {"a", "2"},
+		`true`:     {""},
+		`false`:    nil,
+		`null`:     nil,
+	} {
+		strs, err := JSONStrings(json.RawMessage(in))
+		require.NoError(t, err, in)
+		assert.Equal(t, expect, strs, in)
+	}
+	_, err := JSONStrings(json.RawMessage(`{"a": 1}`))
+	require.Error(t, err)
+}
+
+func TestLint(t *testing.T) {
+	files := &Repeated[string]{PosName: "file", Parse: ParseString}
+	dst := &Required[string]{PosName: "dst", Parse: ParseString}
+	noName := &Required[string]{Parse: ParseString}
+	verbose := &Counter{}
+	leaf := Command{Metadata: Metadata{Short: "leaf"}, F: func(c Context) error { return nil }}
+	root := NewGroupedDir(Metadata{Short: "root"}, []Group{
+		{Title: "Commands", Commands: []string{"cp", "missing"}},
+	}, map[string]Command{
+		"cp": {
+			Metadata: Metadata{Short: "copies files"},
+			Pos:      []Positional{files, dst},
+			Flags:    map[string]Flag{"v": verbose, "dst": dst},
+			F:        leaf.F,
+		},
+		"bad": {Pos: []Positional{noName}, F: leaf.F},
+	})
+	root.Flags = map[string]Flag{"v": verbose}
+
+	var issues []string
+	for _, issue := range Lint(root) {
+		issues = append(issues, issue.String())
+	}
+	assert.Equal(t, []string{
+		`(root): group "Commands" references child "missing" which does not exist`,
+		`bad: missing Metadata.Short`,
+		`bad: positional parameter at index 0 has no PosName`,
+		`cp: positional parameter dst requires a value, but comes after file which accepts many`,
+		`cp: flag --dst is the same parameter as positional dst`,
+		`cp: flag -v is shadowed by the same flag on (root)`,
+	}, issues)
+	assert.Empty(t, Lint(leaf))
+}
+
+// fuzzCommand returns a command with flags and positional parameters chosen by the bits of spec.
+// The command stores its Context in *out when it is run.
+func fuzzCommand(spec uint16, out *Context) Command {
+	newParam := func(kind uint16, posName string) Parameter {
+		switch kind {
+		case 1:
+			return &Required[string]{PosName: posName, Parse: ParseString}
+		case 2:
+			return &Optional[string]{PosName: posName, Parse: ParseString}
+		case 3:
+			return &Repeated[string]{PosName: posName, Parse: ParseString}
+		}
+		return nil
+	}
+	cmd := Command{
+		Flags: map[string]Flag{},
+		F: func(c Context) error {
+			*out = c
+			return nil
+		},
+	}
+	for i, name := range []string{"a", "b", "cc", "dd"} {
+		kind := (spec >> (2 * i)) & 3
+		if kind == 1 && i%2 == 0 {
+			cmd.Flags[name] = &Counter{}
+		} else if p := newParam(kind, ""); p != nil {
+			cmd.Flags[name] = p.(Flag)
+		}
+	}
+	if dd, exists := cmd.Flags["dd"]; exists {
+		cmd.Flags["d"] = dd
+	}
+	for i := 0; i < 3; i++ {
+		if p := newParam((spec>>(8+2*i))&3, fmt.Sprintf("p%d", i)); p != nil {
+			cmd.Pos = append(cmd.Pos, p.(Positional))
+		}
+	}
+	return cmd
+}
+
+// unencodable returns the reason the positional values in vals cannot be passed as arguments to cmd, or "" if they can.
+func unencodable(cmd Command, vals map[Parameter][]any) string {
+	var skipped string
+	for i, p := range cmd.Pos {
+		name := positionalName(p, i)
+		if len(vals[p]) == 0 {
+			if skipped == "" {
+				skipped = name
+			}
+			continue
+		}
+		if skipped != "" {
+			return fmt.Sprintf("%s was skipped to provide a value for %s", skipped, name)
+		}
+		for _, v := range vals[p] {
+			if s := v.(string); isFlag(s) || isShortFlag(cmd.Flags, s) {
+				return fmt.Sprintf("value %q for %s looks like a flag", s, name)
+			}
+		}
+	}
+	return ""
+}
+
+// isSubsequence returns true if xs can be made by removing elements from ys.
+func isSubsequence(xs, ys []string) bool {
+	for _, y := range ys {
+		if len(xs) > 0 && xs[0] == y {
+			xs = xs[1:]
+		}
+	}
+	return len(xs) == 0
+}
+
+func FuzzRun(f *testing.F) {
+	f.Add(uint16(0x0000), "a b c")
+	f.Add(uint16(0x1555), "--a -b x 1 2")
+	f.Add(uint16(0x3f0e), "-ab x --cc y --zz w 1 2 3")
+	f.Add(uint16(0x0d3b), "--dd x -d y --unknown z 1 --b")
+	f.Add(uint16(0x2a2a), "1 --b 2 -- -a 3")
+	f.Fuzz(func(t *testing.T, spec uint16, argLine string) {
+		var args []string
+		if argLine != "" {
+			args = strings.Split(argLine, " ")
+		}
+		var c Context
+		cmd := fuzzCommand(spec, &c)
+		err := Run(context.Background(), cmd, nil, "fuzz", slices.Clone(args), nil, io.Discard, io.Discard)
+		if err != nil {
+			if !IsUsageError(err, UsageMissing) && !IsUsageError(err, UsageTooMany) && !IsUsageError(err, UsagePositional) && !IsUsageError(err, UsageFlag) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+
+		// every parameter has an acceptable number of values.
+		for _, p := range allParams(cmd.Flags, cmd.Pos) {
+			info := p.ParamInfo()
+			if n := len(c.Values[p]); n < info.MinCount || n > info.MaxCount {
+				t.Fatalf("parameter has %d values, must be in [%d, %d]", n, info.MinCount, info.MaxCount)
+			}
+		}
+
+		// arguments which are not used by flags are either positional values or Extra, and none are lost.
+		flagRest, err := parseFlags(valueSet{vals: map[Parameter][]any{}}, cmd.Flags, slices.Clone(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.True(t, isSubsequence(flagRest, args), "%q is not a subsequence of %q", flagRest, args)
+		require.True(t, isSubsequence(c.Extra, flagRest), "%q is not a subsequence of %q", c.Extra, flagRest)
+		var numPos int
+		for _, p := range cmd.Pos {
+			numPos += len(c.Values[p])
+		}
+		require.Equal(t, len(flagRest), len(c.Extra)+numPos, "args after flags: %q, extra: %q", flagRest, c.Extra)
+
+		// formatting the values as arguments, and running them again, produces the same values.
+		args2, err := FormatArgs(cmd, c.Values)
+		if reason := unencodable(cmd, c.Values); reason != "" {
+			require.Error(t, err, reason)
+			return
+		}
+		require.NoError(t, err)
+		want := c.Values
+		c = Context{}
+		require.NoError(t, Run(context.Background(), cmd, nil, "fuzz", slices.Clone(args2), nil, io.Discard, io.Discard), "%q", args2)
+		require.Equal(t, want, c.Values, "%q", args2)
+		require.Empty(t, c.Extra)
+	})
+}
+
+func TestFormatArgs(t *testing.T) {
+	type level int
+	n := &Required[int]{PosName: "n", Parse: strconv.Atoi}
+	timeout := &Optional[time.Duration]{Parse: time.ParseDuration}
+	lvl := &Optional[level]{Choices: Choices[level]{"low": 1, "high": 2}}
+	tags := &List[string]{Parse: ParseString}
+	labels := &Map[string, int]{ParseKey: ParseString, ParseValue: strconv.Atoi}
+	verbose := &Counter{}
+	upper := &Repeated[string]{
+		Parse:  func(x string) (string, error) { return strings.ToUpper(x), nil },
+		Format: strings.ToLower,
+	}
+	var got Context
+	cmd := Command{
+		Pos: []Positional{n},
+		Flags: map[string]Flag{
+			"timeout": timeout,
+			"level":   lvl,
+			"tag":     tags,
+			"label":   labels,
+			"v":       verbose,
+			"upper":   upper,
+		},
+		F: func(c Context) error {
+			got = c
+			return nil
+		},
+	}
+	run := func(args ...string) map[Parameter][]any {
+		require.NoError(t, Run(context.Background(), cmd, nil, "test", args, nil, io.Discard, io.Discard))
+		return got.Values
+	}
+	vals := run("-vv", "--tag", `a,"b,c"`, "--label", "x=1", "--level", "high", "--timeout", "90s", "--upper", "x", "7")
+	args, err := FormatArgs(cmd, vals)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--label", "x=1",
+		"--level", "high",
+		"--tag", `a,"b,c"`,
+		"--timeout", "1m30s",
+		"--upper", "x",
+		"-v", "-v",
+		"7",
+	}, args)
+	assert.Equal(t, vals, run(args...))
+
+	line := QuoteArgs([]string{"a b", "", "it's", "--x=1"})
+	assert.Equal(t, `'a b' '' 'it'\''s' --x=1`, line)
+	split, err := SplitArgs(line)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a b", "", "it's", "--x=1"}, split)
+
+	_, err = FormatArgs(cmd, map[Parameter][]any{n: {"not an int"}})
+	require.Error(t, err)
+}
+
+func TestHiddenAndDeprecated(t *testing.T) {
+	output := &Optional[string]{Parse: ParseString, ShortDoc: "where to write"}
+	debug := &Counter{Hidden: true}
+	legacy := &Optional[string]{Parse: ParseString, Deprecated: &Deprecation{Message: "it does nothing"}}
+	var got string
+	run := Command{
+		Metadata: Metadata{Short: "runs"},
+		Flags: map[string]Flag{
+			"o":        output,
+			"output":   output,
+			"out-file": output,
+			"debug":    debug,
+			"legacy":   legacy,
+		},
+		DeprecatedFlags: map[string]Deprecation{"out-file": {}},
+		F: func(c Context) error {
+			got, _ = output.LoadOpt(c)
+			return nil
+		},
+	}
+	noop := func(c Context) error { return nil }
+	root := NewDir(Metadata{Short: "root"}, map[string]Command{
+		"run":      run,
+		"old":      {Metadata: Metadata{Short: "the old way", Deprecated: &Deprecation{Replacement: "run"}}, F: noop},
+		"internal": {Metadata: Metadata{Short: "internal", Hidden: true}, F: noop},
+	})
+	exec := func(args ...string) (stdout, stderr string) {
+		var outBuf, errBuf bytes.Buffer
+		require.NoError(t, Run(context.Background(), root, nil, "test", args, nil, &outBuf, &errBuf))
+		return outBuf.String(), errBuf.String()
+	}
+
+	_, stderr := exec("run", "--out-file", "a", "--legacy", "x", "--debug")
+	assert.Equal(t, "a", got)
+	assert.Equal(t, "warning: parameter --legacy is deprecated: it does nothing\nwarning: flag --out-file is deprecated, use --output instead\n", stderr)
+	_, stderr = exec("run", "--output", "b")
+	assert.Equal(t, "b", got)
+	assert.Empty(t, stderr)
+	_, stderr = exec("old")
+	assert.Equal(t, "warning: command old is deprecated, use run instead\n", stderr)
+	exec("internal")
+
+	listing, _ := exec()
+	assert.Contains(t, listing, "the old way (deprecated, use run instead)")
+	assert.NotContains(t, listing, "internal")
+	assert.Equal(t, []string{"old", "run"}, Complete(root, []string{""}))
+	assert.Equal(t, []string{"--legacy", "--output"}, Complete(root, []string{"run", "--"}))
+	assert.NotContains(t, run.Doc("run"), "----")
+
+	doc := run.Doc("run")
+	assert.NotContains(t, doc, "--debug")
+	assert.Contains(t, doc, "(deprecated, use --output instead)")
+	assert.Contains(t, doc, "(deprecated: it does nothing)")
+
+	extra := &Optional[string]{PosName: "extra", Parse: ParseString, Hidden: true}
+	withPos := Command{Pos: []Positional{&Required[string]{PosName: "src", Parse: ParseString}, extra}}
+	assert.Equal(t, "cp <src>\n", strings.SplitAfter(withPos.Doc("cp"), "\n")[0])
+	withPos.Pos = withPos.Pos[1:]
+	assert.Contains(t, withPos.Doc("cp"), "POSITIONAL:\n  (this command does not accept any positional parameters)\n")
+
+	schema := NewSchema(run).Commands[0]
+	require.Len(t, schema.Flags, 3)
+	assert.Equal(t, "output", schema.Flags[2].Name)
+	assert.Equal(t, []string{"out-file"}, schema.Flags[2].DeprecatedAliases)
+	assert.True(t, schema.Flags[0].Hidden)
+}
+
+func TestTaggedDir(t *testing.T) {
+	noop := func(c Context) error { return nil }
+	root := NewTaggedDir(Metadata{Short: "root"}, []string{"write", "read"}, map[string]Command{
+		"get":    {Metadata: Metadata{Short: "gets", Tags: []string{"read"}}, F: noop},
+		"list":   {Metadata: Metadata{Short: "lists", Tags: []string{"read"}}, F: noop},
+		"put":    {Metadata: Metadata{Short: "puts", Tags: []string{"write"}}, F: noop},
+		"sync":   {Metadata: Metadata{Short: "syncs", Tags: []string{"write", "read"}}, F: noop},
+		"gc":     {Metadata: Metadata{Short: "collects", Tags: []string{"admin"}}, F: noop},
+		"status": {Metadata: Metadata{Short: "status"}, F: noop},
+	})
+	assert.Equal(t, []Group{
+		{Title: "write", Commands: []string{"put", "sync"}},
+		{Title: "read", Commands: []string{"get", "list", "sync"}},
+		{Title: "admin", Commands: []string{"gc"}},
+		{Title: UntaggedTitle, Commands: []string{"status"}},
+	}, tagGroups([]string{"write", "read"}, root.Children))
+	assert.Empty(t, Lint(root))
+	assert.Contains(t, root.Doc("test"), "--tag")
+	assert.Equal(t, []string{"--tag"}, Complete(root, []string{"--t"}))
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := Run(context.Background(), root, nil, "test", args, nil, &out, io.Discard)
+		return out.String(), err
+	}
+	out, err := run()
+	require.NoError(t, err)
+	assert.Equal(t, "test\n\nroot\n\nwrite:\n  put   puts\n  sync  syncs\n\nread:\n  get   gets\n  list  lists\n  sync  syncs\n\nadmin:\n  gc  collects\n\nOther:\n  status  status\n\nFLAGS:\n  --tag                  only list the commands with this tag (repeated)\n\n", out)
+
+	out, err = run("--tag", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "test\n\nroot\n\nadmin:\n  gc  collects\n\nFLAGS:\n  --tag                  only list the commands with this tag (repeated)\n\n", out)
+
+	_, err = run("--tag", "nope")
+	require.True(t, IsUsageError(err, UsageInvalid))
+
+	_, err = run("get")
+	require.NoError(t, err)
+
+	// children added after NewTaggedDir are listed
+	root = WithBatch(root)
+	out, err = run("--tag=Other")
+	require.NoError(t, err)
+	assert.Equal(t, "test\n\nroot\n\nOther:\n  batch   runs many invocations, one per line of a file\n  status  status\n\nFLAGS:\n  --tag                  only list the commands with this tag (repeated)\n\n", out)
+}
+
+func TestMultiCall(t *testing.T) {
+	var calledAs string
+	// the children added by helpers are not linked
+	root := WithInstallLinks(WithVersion(WithBatch(WithShell(NewDir(Metadata{Short: "root"}, map[string]Command{
+		"get": {
+			Metadata: Metadata{Short: "gets"},
+			F: func(c Context) error {
+				calledAs = c.CalledAs
+				c.Printf("%v\n", c.Extra)
+				return nil
+			},
+		},
+		"internal": {Metadata: Metadata{Short: "internal", Hidden: true}, F: func(c Context) error { return nil }},
+	})))))
+
+	ctx, cmd, name, ok := multiCallChild(context.Background(), root, "/usr/local/bin/get")
+	require.True(t, ok)
+	assert.Equal(t, "get", name)
+	var out bytes.Buffer
+	require.NoError(t, Run(ctx, cmd, nil, name, []string{"a", "b"}, nil, &out, io.Discard))
+	assert.Equal(t, "get", calledAs)
+	assert.Equal(t, "[a b]\n", out.String())
+
+	_, cmd, name, ok = multiCallChild(context.Background(), root, "/usr/local/bin/tool")
+	require.False(t, ok)
+	assert.Equal(t, "/usr/local/bin/tool", name)
+	assert.True(t, cmd.IsDir())
+
+	dir := t.TempDir()
+	install := func(args ...string) error {
+		return Run(context.Background(), root, nil, "tool", append([]string{"install-links", dir}, args...), nil, io.Discard, io.Discard)
+	}
+	require.NoError(t, install())
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	exe, err = filepath.EvalSymlinks(exe)
+	require.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(dir, "get"))
+	require.NoError(t, err)
+	assert.Equal(t, exe, target)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.Error(t, install())
+	require.NoError(t, install("--force"))
+
+	assert.Equal(t, "get", exeName("get", "linux"))
+	assert.Equal(t, "get.exe", exeName("get", "windows"))
+
+	// Main runs the child named by the program, with all of the arguments
+	cfg := mainConfig{Background: context.Background()}
+	MainMultiCall()(&cfg)
+	out.Reset()
+	require.NoError(t, runMain(cfg, root, "/usr/local/bin/get.exe", []string{"c"}, nil, &out, io.Discard))
+	assert.Equal(t, "get", calledAs)
+	assert.Equal(t, "[c]\n", out.String())
+	out.Reset()
+	require.NoError(t, runMain(cfg, root, "/usr/local/bin/tool", []string{"get", "d"}, nil, &out, io.Discard))
+	assert.Equal(t, "[d]\n", out.String())
+	err = runMain(mainConfig{Background: context.Background()}, root, "/usr/local/bin/get", []string{"c"}, nil, io.Discard, io.Discard)
+	assert.Equal(t, UsageUnknownCommand, err.(*UsageError).Kind)
+}
+
+func TestFlagEquals(t *testing.T) {
+	verbose := &Counter{}
+	name := &Required[string]{Parse: ParseString}
+	arg := &Required[string]{PosName: "arg", Parse: ParseString}
+	cmd := Command{
+		Flags: map[string]Flag{"v": verbose, "name": name},
+		Pos:   []Positional{arg},
+		F: func(c Context) error {
+			c.Printf("%s %s %d", name.Load(c), arg.Load(c), verbose.Load(c))
+			return nil
+		},
+	}
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), cmd, nil, "test", []string{"--name=a=b", "x", "--v"}, nil, &out, io.Discard))
+	assert.Equal(t, "a=b x 1", out.String())
+
+	err := Run(context.Background(), cmd, nil, "test", []string{"--name=a", "--v=1", "x"}, nil, io.Discard, io.Discard)
+	require.ErrorContains(t, err, "does not take a value")
+}
+
+func TestVersion(t *testing.T) {
+	BuildVersion, BuildRevision, BuildTime = "v1.2.3", "abc123", "2024-01-02T03:04:05Z"
+	defer func() { BuildVersion, BuildRevision, BuildTime = "", "", "" }()
+
+	root := WithVersion(NewDir(Metadata{Short: "root"}, map[string]Command{}))
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		require.NoError(t, Run(context.Background(), root, nil, "test", args, nil, &out, io.Discard))
+		return out.String()
+	}
+	assert.Contains(t, run(), "version  prints version information")
+
+	out := run("version")
+	assert.Contains(t, out, "version   v1.2.3\n")
+	assert.Contains(t, out, "revision  abc123\n")
+	assert.Contains(t, out, "time      2024-01-02T03:04:05Z\n")
+
+	var vi VersionInfo
+	require.NoError(t, json.Unmarshal([]byte(run("version", "--output=json")), &vi))
+	assert.Equal(t, "v1.2.3", vi.Version)
+	assert.Equal(t, "abc123", vi.Revision)
+	assert.Equal(t, "2024-01-02T03:04:05Z", vi.Time)
+	assert.Empty(t, Lint(root))
+
+	var buf bytes.Buffer
+	_, err := VersionInfo{Version: "v1"}.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "version  v1\n", buf.String())
+	buf.Reset()
+	_, err = VersionInfo{Version: "v1", Dirty: Ptr(false)}.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "version  v1\ndirty    false\n", buf.String())
+
+	// MainVersion adds --version to the root, which can come anywhere it would be parsed
+	var ran bool
+	leaf := Command{
+		Metadata: Metadata{Short: "leaf"},
+		Pos:      []Positional{&Required[string]{PosName: "x", Parse: ParseString}},
+		F: func(c Context) error {
+			ran = true
+			return nil
+		},
+	}
+	root = NewDir(Metadata{Short: "root"}, map[string]Command{"leaf": leaf})
+	cfg := mainConfig{Background: context.Background()}
+	MainVersion()(&cfg)
+	mainOut := func(cmd Command, args ...string) string {
+		var out bytes.Buffer
+		require.NoError(t, runMain(cfg, cmd, "test", args, nil, &out, io.Discard))
+		return out.String()
+	}
+	assert.Contains(t, mainOut(root, "--version"), "version   v1.2.3\n")
+	assert.Contains(t, mainOut(root, "leaf", "--version"), "version   v1.2.3\n")
+	assert.Contains(t, mainOut(leaf, "--version"), "version   v1.2.3\n")
+	assert.False(t, ran)
+	assert.Contains(t, mainOut(root), "--version              prints version information and exits")
+	mainOut(root, "leaf", "a")
+	assert.True(t, ran)
+	var stderr bytes.Buffer
+	require.Error(t, runMain(cfg, leaf, "test", nil, nil, io.Discard, &stderr))
+	assert.Contains(t, stderr.String(), "--version")
+}
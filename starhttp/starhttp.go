@@ -0,0 +1,164 @@
+// package starhttp serves a star command tree over HTTP, with JSON requests and responses.
+package starhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.brendoncarroll.net/star"
+)
+
+// Frame is a single line of the newline delimited JSON written in response to a request.
+// Every frame, except the last, has Stdout set to some of the output of the command.
+// The last frame has Done set, along with the StdErr output and the error, if any.
+type Frame struct {
+	Stdout string `json:"stdout,omitempty"`
+
+	Done     bool   `json:"done,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ErrorResponse is the body of a response for a request which could not be run.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves the leaf commands in a tree over HTTP.
+//
+// A command is run by a POST to its path, e.g. POST /path/to/cmd.
+// The body is a JSON object, mapping the names of parameters to a string, a number, or an array of them.  See star.JSONStrings.
+// Flags which do not take a value can also be set to true.
+// Names are the same as in the star.Schema for the command.
+//
+// The response is newline delimited JSON, made of Frames, which are written as the command produces output.
+// The request's context is passed to the command, so it will be cancelled if the client goes away.
+// Hidden commands are not served.
+type Handler struct {
+	name string
+	root star.Command
+}
+
+// NewHandler returns a Handler for the command tree root.
+// name is used as the name the root command was called as.
+func NewHandler(name string, root star.Command) *Handler {
+	return &Handler{name: name, root: root}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	path := splitPath(r.URL.Path)
+	cmd, ok := h.lookup(path)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no command found for %q", r.URL.Path))
+		return
+	}
+	var body map[string]json.RawMessage
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request body: %w", err))
+			return
+		}
+	}
+	vals := make(map[string][]string, len(body))
+	for name, raw := range body {
+		strs, err := star.JSONStrings(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parameter %q: %w", name, err))
+			return
+		}
+		vals[name] = strs
+	}
+	args, err := star.BuildArgs(cmd, vals)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	fw := &frameWriter{enc: json.NewEncoder(w)}
+	if f, ok := w.(http.Flusher); ok {
+		fw.flush = f.Flush
+	}
+	var stderr bytes.Buffer
+	err = star.Run(r.Context(), h.root, map[string]string{}, h.name, append(path, args...), strings.NewReader(""), fw, &stderr)
+	final := Frame{Done: true, Stderr: stderr.String()}
+	if err != nil {
+		final.ExitCode, final.Error = star.ExitCode(err), err.Error()
+	}
+	fw.writeFrame(final)
+}
+
+// lookup returns the command at path, if it is a visible leaf.
+func (h *Handler) lookup(path []string) (star.Command, bool) {
+	cmd := h.root
+	for _, name := range path {
+		child, ok := cmd.Children[name]
+		if !ok || child.Hidden {
+			return star.Command{}, false
+		}
+		cmd = child
+	}
+	if cmd.IsDir() {
+		return star.Command{}, false
+	}
+	return cmd, true
+}
+
+func splitPath(p string) (ret []string) {
+	for _, part := range strings.Split(p, "/") {
+		if part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
+
+// frameWriter writes each call to Write as a Frame, and flushes it to the client.
+type frameWriter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	flush func()
+	err   error
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := fw.writeFrame(Frame{Stdout: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (fw *frameWriter) writeFrame(fr Frame) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.err != nil {
+		return fw.err
+	}
+	if fw.err = fw.enc.Encode(fr); fw.err != nil {
+		return fw.err
+	}
+	if fw.flush != nil {
+		fw.flush()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package starhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.brendoncarroll.net/star"
+)
+
+func TestHandler(t *testing.T) {
+	name := &star.Required[string]{PosName: "name", Parse: star.ParseString}
+	loud := &star.Counter{}
+	cancelled := make(chan struct{})
+	root := star.NewDir(star.Metadata{}, map[string]star.Command{
+		"greet": {
+			Pos:   []star.Positional{name},
+			Flags: map[string]star.Flag{"loud": loud},
+			F: func(c star.Context) error {
+				msg := "hello " + name.Load(c)
+				if loud.Load(c) > 0 {
+					msg = strings.ToUpper(msg)
+				}
+				c.Printf("%s\n", msg)
+				fmt.Fprintf(c.StdErr, "greeted\n")
+				return nil
+			},
+		},
+		"fail": {
+			F: func(c star.Context) error {
+				return fmt.Errorf("failed")
+			},
+		},
+		"block": {
+			F: func(c star.Context) error {
+				c.Printf("started\n")
+				<-c.Done()
+				close(cancelled)
+				return c.Err()
+			},
+		},
+		"secret": {Metadata: star.Metadata{Hidden: true}, F: func(c star.Context) error { return nil }},
+	})
+	srv := httptest.NewServer(NewHandler("test", root))
+	defer srv.Close()
+
+	post := func(ctx context.Context, path, body string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	readFrames := func(resp *http.Response) (ret []Frame) {
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var fr Frame
+			require.NoError(t, dec.Decode(&fr))
+			ret = append(ret, fr)
+		}
+		return ret
+	}
+	ctx := context.Background()
+
+	resp := post(ctx, "/greet", `{"name": "world", "loud": true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []Frame{
+		{Stdout: "HELLO WORLD\n"},
+		{Done: true, Stderr: "greeted\n"},
+	}, readFrames(resp))
+
+	resp = post(ctx, "/fail", `{}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []Frame{{Done: true, ExitCode: 1, Error: "failed"}}, readFrames(resp))
+
+	for path, code := range map[string]int{
+		"/nope":   http.StatusNotFound,
+		"/secret": http.StatusNotFound,
+		"/":       http.StatusNotFound,
+	} {
+		resp = post(ctx, path, `{}`)
+		assert.Equal(t, code, resp.StatusCode, path)
+		resp.Body.Close()
+	}
+	resp = post(ctx, "/greet", `{"unknown": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var errResp ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	resp.Body.Close()
+	assert.Contains(t, errResp.Error, "unknown")
+
+	// the first frame is streamed before the command returns, and cancelling the request cancels the command.
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp = post(ctx2, "/block", `{}`)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"stdout": "started\n"}`, line)
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not cancelled")
+	}
+}
@@ -0,0 +1,312 @@
+// package starmcp serves a star command tree as tools over the Model Context Protocol.
+package starmcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.brendoncarroll.net/star"
+)
+
+// ProtocolVersion is the version of the Model Context Protocol implemented by Server.
+const ProtocolVersion = "2024-11-05"
+
+// Server serves the leaf commands in a tree as MCP tools.
+// Each tool is named by the path to its command, joined with underscores.
+// Hidden commands, and hidden or secret parameters are not exposed.
+//
+// Secret parameters can only be provided by the environment passed with WithEnv, or by a config in the context passed to Serve.
+// Commands which require a secret parameter that cannot be provided that way are not exposed.
+type Server struct {
+	name    string
+	version string
+	root    star.Command
+	env     map[string]string
+	tools   map[string]tool
+	order   []string
+}
+
+// Option configures a Server.
+type Option = func(*Server)
+
+// WithEnv returns an Option which sets the environment passed to commands.
+func WithEnv(env map[string]string) Option {
+	return func(s *Server) {
+		s.env = env
+	}
+}
+
+type tool struct {
+	path []string
+	cmd  star.Command
+	desc toolDesc
+}
+
+// NewServer returns a Server for the command tree root.
+// name and version identify the server to clients.
+// NewServer panics if two commands would have the same tool name e.g. "a_b" and "a b".
+func NewServer(name, version string, root star.Command, opts ...Option) *Server {
+	s := &Server{
+		name:    name,
+		version: version,
+		root:    root,
+		env:     map[string]string{},
+		tools:   make(map[string]tool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	star.Walk(root, func(path []string, c star.Command) error {
+		if c.Hidden {
+			return star.SkipChildren
+		}
+		if c.IsDir() || !s.canProvideSecrets(c) {
+			return nil
+		}
+		toolName := strings.Join(path, "_")
+		if toolName == "" {
+			toolName = name
+		}
+		if prev, exists := s.tools[toolName]; exists {
+			panic(fmt.Sprintf("commands %q and %q would both be the tool %q", strings.Join(prev.path, " "), strings.Join(path, " "), toolName))
+		}
+		s.tools[toolName] = tool{
+			path: append([]string{}, path...),
+			cmd:  c,
+			desc: newToolDesc(toolName, c),
+		}
+		s.order = append(s.order, toolName)
+		return nil
+	})
+	return s
+}
+
+// canProvideSecrets returns false if c has a secret parameter which requires a value,
+// and has no default, environment variable in the Server's environment, or config key to provide one.
+func (s *Server) canProvideSecrets(c star.Command) bool {
+	params := make([]star.Parameter, 0, len(c.Pos)+len(c.Flags))
+	for _, pos := range c.Pos {
+		params = append(params, pos)
+	}
+	for _, flag := range c.Flags {
+		params = append(params, flag)
+	}
+	for _, param := range params {
+		info := param.ParamInfo()
+		if !info.Secret || info.MinCount == 0 || len(info.Defaults) > 0 || info.ConfigKey != "" {
+			continue
+		}
+		if _, exists := s.env[info.Env]; info.Env == "" || !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// Serve reads JSON-RPC messages from r, one per line, and writes responses to w, until r is exhausted or ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		resp := s.handle(ctx, line)
+		if resp == nil {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// handle returns the response for a single message, or nil if it was a notification.
+func (s *Server) handle(ctx context.Context, data []byte) *response {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}}
+	}
+	if len(req.ID) == 0 {
+		// notifications do not get a response.
+		return nil
+	}
+	result, rerr := s.dispatch(ctx, req)
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr}
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": ProtocolVersion,
+			"capabilities": map[string]any{
+				"tools": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    s.name,
+				"version": s.version,
+			},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		tools := make([]toolDesc, 0, len(s.order))
+		for _, name := range s.order {
+			tools = append(tools, s.tools[name].desc)
+		}
+		return map[string]any{"tools": tools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string                     `json:"name"`
+			Arguments map[string]json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		t, exists := s.tools[params.Name]
+		if !exists {
+			return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+		}
+		return s.call(ctx, t, params.Arguments), nil
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type callResult struct {
+	Content []content `json:"content"`
+	IsError bool      `json:"isError"`
+}
+
+// call runs the command for t, and returns its output as the result of the tool call.
+func (s *Server) call(ctx context.Context, t tool, arguments map[string]json.RawMessage) callResult {
+	args, err := toolArgs(t, arguments)
+	if err != nil {
+		return callResult{Content: []content{{Type: "text", Text: err.Error()}}, IsError: true}
+	}
+	var stdout, stderr bytes.Buffer
+	err = star.Run(ctx, s.root, s.env, s.name, append(append([]string{}, t.path...), args...), strings.NewReader(""), &stdout, &stderr)
+	res := callResult{Content: []content{{Type: "text", Text: stdout.String()}}}
+	if stderr.Len() > 0 {
+		res.Content = append(res.Content, content{Type: "text", Text: stderr.String()})
+	}
+	if err != nil {
+		res.Content = append(res.Content, content{Type: "text", Text: "error: " + err.Error()})
+		res.IsError = true
+	}
+	return res
+}
+
+// toolArgs converts the JSON arguments for a tool into command line arguments.
+func toolArgs(t tool, arguments map[string]json.RawMessage) ([]string, error) {
+	vals := make(map[string][]string)
+	for name, raw := range arguments {
+		if _, exists := t.desc.InputSchema.Properties[name]; !exists {
+			return nil, fmt.Errorf("unknown argument %q", name)
+		}
+		strs, err := star.JSONStrings(raw)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", name, err)
+		}
+		vals[name] = strs
+	}
+	return star.BuildArgs(t.cmd, vals)
+}
+
+type toolDesc struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema inputSchema `json:"inputSchema"`
+}
+
+type inputSchema struct {
+	Type       string                  `json:"type"`
+	Properties map[string]propertyDesc `json:"properties"`
+	Required   []string                `json:"required,omitempty"`
+}
+
+type propertyDesc struct {
+	Type        string        `json:"type"`
+	Description string        `json:"description,omitempty"`
+	Enum        []string      `json:"enum,omitempty"`
+	Items       *propertyDesc `json:"items,omitempty"`
+}
+
+func newToolDesc(name string, c star.Command) toolDesc {
+	schema := star.NewSchema(c).Commands[0]
+	ret := toolDesc{
+		Name:        name,
+		Description: c.Short,
+		InputSchema: inputSchema{
+			Type:       "object",
+			Properties: make(map[string]propertyDesc),
+		},
+	}
+	for _, ps := range append(schema.Positional, schema.Flags...) {
+		if ps.Secret || ps.Hidden {
+			continue
+		}
+		ret.InputSchema.Properties[ps.Name] = newPropertyDesc(ps)
+		if ps.MinCount > 0 && len(ps.Defaults) == 0 {
+			ret.InputSchema.Required = append(ret.InputSchema.Required, ps.Name)
+		}
+	}
+	return ret
+}
+
+func newPropertyDesc(ps star.ParamSchema) propertyDesc {
+	if ps.NoValue {
+		return propertyDesc{Type: "boolean", Description: ps.Doc}
+	}
+	item := propertyDesc{Type: "string", Description: ps.Doc, Enum: ps.Choices}
+	if ps.MaxCount != nil && *ps.MaxCount == 1 {
+		return item
+	}
+	return propertyDesc{
+		Type:        "array",
+		Description: ps.Doc,
+		Items:       &propertyDesc{Type: "string", Enum: ps.Choices},
+	}
+}
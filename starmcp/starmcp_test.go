@@ -0,0 +1,110 @@
+package starmcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.brendoncarroll.net/star"
+)
+
+func TestServer(t *testing.T) {
+	name := &star.Required[string]{PosName: "name", Parse: star.ParseString, ShortDoc: "who to greet"}
+	loud := &star.Counter{ShortDoc: "shout"}
+	token := &star.Optional[string]{Parse: star.ParseString, Secret: true}
+	apiKey := &star.Required[string]{Parse: star.ParseString, Secret: true, Env: "API_KEY"}
+	root := star.NewDir(star.Metadata{}, map[string]star.Command{
+		"greet": {
+			Metadata: star.Metadata{Short: "greets someone"},
+			Pos:      []star.Positional{name},
+			Flags:    map[string]star.Flag{"loud": loud, "token": token},
+			F: func(c star.Context) error {
+				msg := "hello " + name.Load(c)
+				if loud.Load(c) > 0 {
+					msg = strings.ToUpper(msg)
+				}
+				c.Printf("%s\n", msg)
+				return nil
+			},
+		},
+		"fetch": {
+			Metadata: star.Metadata{Short: "fetches with a key"},
+			Flags:    map[string]star.Flag{"api-key": apiKey},
+			F: func(c star.Context) error {
+				c.Printf("%d\n", len(apiKey.Load(c)))
+				return nil
+			},
+		},
+		"internal": star.NewDir(star.Metadata{Hidden: true}, map[string]star.Command{
+			"debug": {F: func(c star.Context) error { return nil }},
+		}),
+	})
+	s := NewServer("test", "v0", root, WithEnv(map[string]string{"API_KEY": "secret"}))
+	// without the environment, fetch could never succeed.
+	assert.NotContains(t, NewServer("test", "v0", root).tools, "fetch")
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"greet","arguments":{"name":"world","loud":true}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"greet","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"greet","arguments":{"token":"abc"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"unknown"}`,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"fetch","arguments":{}}}`,
+	}, "\n")
+	var out bytes.Buffer
+	require.NoError(t, s.Serve(context.Background(), strings.NewReader(input), &out))
+
+	var resps []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp map[string]any
+		require.NoError(t, dec.Decode(&resp))
+		resps = append(resps, resp)
+	}
+	require.Len(t, resps, 7)
+
+	init := resps[0]["result"].(map[string]any)
+	assert.Equal(t, ProtocolVersion, init["protocolVersion"])
+
+	tools := resps[1]["result"].(map[string]any)["tools"].([]any)
+	require.Len(t, tools, 2)
+	greet := tools[1].(map[string]any)
+	assert.Equal(t, "greet", greet["name"])
+	assert.Equal(t, "greets someone", greet["description"])
+	schema := greet["inputSchema"].(map[string]any)
+	props := schema["properties"].(map[string]any)
+	assert.Contains(t, props, "name")
+	assert.Contains(t, props, "loud")
+	assert.NotContains(t, props, "token")
+	assert.Equal(t, "boolean", props["loud"].(map[string]any)["type"])
+	assert.Equal(t, "string", props["name"].(map[string]any)["type"])
+	assert.Equal(t, []any{"name"}, schema["required"])
+
+	call := resps[2]["result"].(map[string]any)
+	assert.Equal(t, false, call["isError"])
+	assert.Equal(t, "HELLO WORLD\n", call["content"].([]any)[0].(map[string]any)["text"])
+
+	assert.Equal(t, true, resps[3]["result"].(map[string]any)["isError"])
+	assert.Equal(t, true, resps[4]["result"].(map[string]any)["isError"])
+	assert.Equal(t, float64(codeMethodNotFound), resps[5]["error"].(map[string]any)["code"])
+
+	call = resps[6]["result"].(map[string]any)
+	assert.Equal(t, false, call["isError"])
+	assert.Equal(t, "6\n", call["content"].([]any)[0].(map[string]any)["text"])
+}
+
+func TestToolNameCollision(t *testing.T) {
+	noop := func(c star.Context) error { return nil }
+	root := star.NewDir(star.Metadata{}, map[string]star.Command{
+		"a_b": {F: noop},
+		"a":   star.NewDir(star.Metadata{}, map[string]star.Command{"b": {F: noop}}),
+	})
+	assert.Panics(t, func() { NewServer("test", "v0", root) })
+}
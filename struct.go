@@ -0,0 +1,255 @@
+package star
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NewCommand returns a Command with parameters declared by the fields of the struct type A.
+// Before f is called, a value of type A is filled in from the parsed parameters.
+//
+// Fields are declared as parameters using struct tags:
+//   - flag:"name" the field is passed as the flag --name
+//   - pos:"0" the field is passed as the positional argument at that index
+//   - doc:"..." the ShortDoc for the parameter
+//   - default:"..." the default value, parsed the same way as an argument
+//   - env:"NAME" the environment variable which can provide the value
+//
+// Fields without a flag or pos tag are ignored.
+// The kind of parameter depends on the type of the field:
+//   - T is Required, unless it has a default.
+//   - *T is Optional.
+//   - []T is Repeated.
+//   - bool is a Boolean flag, which takes no value, and is true if it is passed.  It cannot have env or default tags.
+//
+// Parsers are inferred from T, which can be a string, bool, integer, float, time.Duration,
+// or a type whose pointer implements encoding.TextUnmarshaler.
+//
+// NewCommand panics if A is not a struct, or has fields which cannot be parameters.
+func NewCommand[A any](md Metadata, f func(c Context, args A) error) Command {
+	ty := reflect.TypeFor[A]()
+	if ty.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("NewCommand: %v is not a struct", ty))
+	}
+	var fields []structField
+	var pos []structField
+	flags := map[string]Flag{}
+	for i := 0; i < ty.NumField(); i++ {
+		sf := ty.Field(i)
+		flagName, isFlag := sf.Tag.Lookup("flag")
+		posIndex, isPos := sf.Tag.Lookup("pos")
+		if !sf.IsExported() || (!isFlag && !isPos) {
+			continue
+		}
+		field := newStructField(sf)
+		switch {
+		case isFlag && isPos:
+			panic(fmt.Sprintf("NewCommand: field %s cannot have both flag and pos tags", sf.Name))
+		case isFlag:
+			flags[flagName] = field.param.(Flag)
+		case isPos:
+			idx, err := strconv.Atoi(posIndex)
+			if err != nil {
+				panic(fmt.Sprintf("NewCommand: field %s has invalid pos tag: %v", sf.Name, err))
+			}
+			if _, ok := field.param.(Positional); !ok {
+				panic(fmt.Sprintf("NewCommand: field %s cannot be positional", sf.Name))
+			}
+			field.posIndex = idx
+			pos = append(pos, field)
+		}
+		fields = append(fields, field)
+	}
+	slices.SortFunc(pos, func(a, b structField) int {
+		return a.posIndex - b.posIndex
+	})
+	var posParams []Positional
+	for i, field := range pos {
+		if field.posIndex != i {
+			panic(fmt.Sprintf("NewCommand: positional fields must have contiguous indexes starting from 0, missing %d", i))
+		}
+		posParams = append(posParams, field.param.(Positional))
+	}
+	return Command{
+		Metadata: md,
+		Flags:    flags,
+		Pos:      posParams,
+		F: func(c Context) error {
+			var args A
+			v := reflect.ValueOf(&args).Elem()
+			for _, field := range fields {
+				field.load(c, v.FieldByIndex(field.index))
+			}
+			return f(c, args)
+		},
+	}
+}
+
+type structField struct {
+	index    []int
+	posIndex int
+	param    Parameter
+	load     func(c Context, dst reflect.Value)
+}
+
+func newStructField(sf reflect.StructField) structField {
+	doc := sf.Tag.Get("doc")
+	env := sf.Tag.Get("env")
+	defStr, hasDefault := sf.Tag.Lookup("default")
+	posName := kebabCase(sf.Name)
+	ret := structField{index: sf.Index}
+
+	switch {
+	case sf.Type.Kind() == reflect.Bool:
+		if env != "" || hasDefault {
+			panic(fmt.Sprintf("NewCommand: bool field %s cannot have env or default tags, use *bool to accept a value", sf.Name))
+		}
+		p := &Boolean{ShortDoc: doc}
+		ret.param = p
+		ret.load = func(c Context, dst reflect.Value) {
+			dst.SetBool(p.Load(c))
+		}
+	case sf.Type.Kind() == reflect.Pointer:
+		p := &Optional[any]{PosName: posName, Parse: mustReflectParser(sf, sf.Type.Elem()), Env: env, ShortDoc: doc, typ: sf.Type.Elem().String()}
+		if hasDefault {
+			p.Default = Ptr(mustParseDefault(sf, p.Parse, defStr))
+		}
+		ret.param = p
+		ret.load = func(c Context, dst reflect.Value) {
+			if x, ok := p.LoadOpt(c); ok {
+				ptr := reflect.New(sf.Type.Elem())
+				ptr.Elem().Set(reflect.ValueOf(x))
+				dst.Set(ptr)
+			}
+		}
+	case sf.Type.Kind() == reflect.Slice && sf.Type.Elem().Kind() != reflect.Uint8:
+		p := &Repeated[any]{PosName: posName, Parse: mustReflectParser(sf, sf.Type.Elem()), Env: env, ShortDoc: doc, typ: sf.Type.Elem().String()}
+		if hasDefault {
+			p.Default = []any{mustParseDefault(sf, p.Parse, defStr)}
+		}
+		ret.param = p
+		ret.load = func(c Context, dst reflect.Value) {
+			xs := p.Load(c)
+			if len(xs) == 0 {
+				return
+			}
+			slice := reflect.MakeSlice(sf.Type, len(xs), len(xs))
+			for i, x := range xs {
+				slice.Index(i).Set(reflect.ValueOf(x))
+			}
+			dst.Set(slice)
+		}
+	default:
+		p := &Required[any]{PosName: posName, Parse: mustReflectParser(sf, sf.Type), Env: env, ShortDoc: doc, typ: sf.Type.String()}
+		if hasDefault {
+			p.Default = Ptr(mustParseDefault(sf, p.Parse, defStr))
+		}
+		ret.param = p
+		ret.load = func(c Context, dst reflect.Value) {
+			dst.Set(reflect.ValueOf(p.Load(c)))
+		}
+	}
+	return ret
+}
+
+func mustReflectParser(sf reflect.StructField, ty reflect.Type) Parser[any] {
+	parse, err := reflectParser(ty)
+	if err != nil {
+		panic(fmt.Sprintf("NewCommand: field %s: %v", sf.Name, err))
+	}
+	return parse
+}
+
+func mustParseDefault(sf reflect.StructField, parse Parser[any], x string) any {
+	v, err := parse(x)
+	if err != nil {
+		panic(fmt.Sprintf("NewCommand: field %s has invalid default: %v", sf.Name, err))
+	}
+	return v
+}
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// reflectParser returns a Parser which produces values of type ty.
+func reflectParser(ty reflect.Type) (Parser[any], error) {
+	if reflect.PointerTo(ty).Implements(textUnmarshalerType) {
+		return func(x string) (any, error) {
+			ptr := reflect.New(ty)
+			if err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(x)); err != nil {
+				return nil, err
+			}
+			return ptr.Elem().Interface(), nil
+		}, nil
+	}
+	if ty == durationType {
+		return func(x string) (any, error) {
+			return time.ParseDuration(x)
+		}, nil
+	}
+	switch ty.Kind() {
+	case reflect.String:
+		return func(x string) (any, error) {
+			return reflect.ValueOf(x).Convert(ty).Interface(), nil
+		}, nil
+	case reflect.Bool:
+		return func(x string) (any, error) {
+			b, err := strconv.ParseBool(x)
+			if err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(b).Convert(ty).Interface(), nil
+		}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(x string) (any, error) {
+			n, err := strconv.ParseInt(x, 10, ty.Bits())
+			if err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(n).Convert(ty).Interface(), nil
+		}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(x string) (any, error) {
+			n, err := strconv.ParseUint(x, 10, ty.Bits())
+			if err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(n).Convert(ty).Interface(), nil
+		}, nil
+	case reflect.Float32, reflect.Float64:
+		return func(x string) (any, error) {
+			f, err := strconv.ParseFloat(x, ty.Bits())
+			if err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(f).Convert(ty).Interface(), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("no parser for type %v", ty)
+	}
+}
+
+// kebabCase converts a Go identifier like OutputDir to output-dir.
+// A run of capitals is one word, so HTTPAddr becomes http-addr.
+func kebabCase(x string) string {
+	rs := []rune(x)
+	sb := &strings.Builder{}
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+				sb.WriteRune('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,117 @@
+package teststar
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// update is namespaced, so it does not conflict with an -update flag defined by the package being tested.
+var update = flag.Bool("teststar.update", false, "rewrite golden files in testdata/ with the actual output")
+
+// GoldenDir is the directory golden files are stored in, relative to the package being tested.
+const GoldenDir = "testdata"
+
+// Normalizer replaces volatile content in output, so it can be compared with a golden file.
+type Normalizer = func(string) string
+
+// ReplaceString returns a Normalizer which replaces all occurrences of old with repl.
+// It is useful for temporary paths, e.g. ReplaceString(t.TempDir(), "$TMP").
+func ReplaceString(old, repl string) Normalizer {
+	return func(x string) string {
+		if old == "" {
+			return x
+		}
+		return strings.ReplaceAll(x, old, repl)
+	}
+}
+
+// ReplaceRegexp returns a Normalizer which replaces all matches of re with repl.
+// repl can refer to submatches, as in regexp.Regexp.ReplaceAllString.
+func ReplaceRegexp(re *regexp.Regexp, repl string) Normalizer {
+	return func(x string) string {
+		return re.ReplaceAllString(x, repl)
+	}
+}
+
+var timestampRe = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+
+// NormalizeTimestamps replaces RFC 3339 style timestamps with <TIMESTAMP>.
+func NormalizeTimestamps(x string) string {
+	return timestampRe.ReplaceAllString(x, "<TIMESTAMP>")
+}
+
+// Golden checks that actual, after applying norms, is equal to the contents of testdata/<name>.golden.
+// If the test is run with -teststar.update, then the file is written instead.
+func Golden(t testing.TB, name string, actual string, norms ...Normalizer) {
+	t.Helper()
+	for _, norm := range norms {
+		actual = norm(actual)
+	}
+	p := filepath.Join(GoldenDir, name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(actual), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated golden file %s", p)
+		return
+	}
+	expected, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist, run the test with -teststar.update to create it", p)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) == actual {
+		return
+	}
+	t.Fatalf("output does not match golden file %s, run the test with -teststar.update to accept it\n%s", p, diff(p, string(expected), actual))
+}
+
+// diff returns a unified diff from expected to actual, by line.
+func diff(name, expected, actual string) string {
+	ret, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(expected),
+		B:        splitLines(actual),
+		FromFile: name,
+		ToFile:   "actual",
+		Context:  3,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// splitLines splits x into lines, each ending in a newline.
+// A last line without a newline is marked the way diff does, so a missing newline shows up in the diff.
+func splitLines(x string) []string {
+	lines := strings.SplitAfter(x, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+// StdoutMatchesGolden checks the output of the command against a golden file.  See Golden.
+func (r *Result) StdoutMatchesGolden(name string, norms ...Normalizer) *Result {
+	r.t.Helper()
+	Golden(r.t, name, r.Stdout, norms...)
+	return r
+}
+
+// StderrMatchesGolden checks the error output of the command against a golden file.  See Golden.
+func (r *Result) StderrMatchesGolden(name string, norms ...Normalizer) *Result {
+	r.t.Helper()
+	Golden(r.t, name, r.Stderr, norms...)
+	return r
+}
@@ -0,0 +1,239 @@
+package teststar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.brendoncarroll.net/star"
+)
+
+// RunScripts runs each of the files matching dir/*.txtar as a script against cmd, in a subtest.  See RunScript.
+func RunScripts(t *testing.T, cmd *star.Command, dir string) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no scripts found in %s", dir)
+	}
+	for _, p := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(p), ".txtar"), func(t *testing.T) {
+			RunScript(t, cmd, p)
+		})
+	}
+}
+
+// RunScript runs the script in the txtar archive at p against cmd.
+//
+// The files in the archive are written to a temporary directory, which is available to the script as $WORK.
+// The comment section of the archive is the script, which has one command per line.
+// Lines are split into arguments with star.SplitArgsExpand, so $VAR or ${VAR} are replaced with values set by env,
+// except inside single quotes.
+// Blank lines, and lines starting with # are ignored.
+//
+// The commands are:
+//   - star ARGS...: runs cmd with ARGS, and fails if it returns an error.
+//   - ! star ARGS...: runs cmd with ARGS, and fails if it does not return an error.
+//   - stdout REGEXP, stderr REGEXP: fails if the output of the last command does not match REGEXP.
+//   - ! stdout REGEXP, ! stderr REGEXP: fails if the output of the last command matches REGEXP.
+//   - cmp stdout FILE, cmp stderr FILE: fails if the output of the last command is not the same as FILE.
+//   - stdin FILE: uses the contents of FILE as the input to the next command.
+//   - env KEY=VALUE: sets an environment variable for the following commands.
+//
+// Commands are run in-process with star.Run, so no binary needs to be built.
+// If a command returns an error, it is written to stderr, as star.Main would.
+func RunScript(t testing.TB, cmd *star.Command, p string) {
+	t.Helper()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := parseTxtar(data)
+	work := t.TempDir()
+	for _, f := range ar.files {
+		fp := filepath.Join(work, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, f.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &scriptState{
+		cmd:  *cmd,
+		work: work,
+		env:  map[string]string{"WORK": work},
+	}
+	for i, line := range strings.Split(string(ar.comment), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.exec(line); err != nil {
+			t.Fatalf("%s:%d: %s: %v\nSTDOUT: ---\n%s---\nSTDERR: ---\n%s---", p, i+1, line, err, s.stdout, s.stderr)
+		}
+	}
+}
+
+type scriptState struct {
+	cmd   star.Command
+	work  string
+	env   map[string]string
+	stdin []byte
+
+	stdout, stderr string
+}
+
+func (s *scriptState) exec(line string) error {
+	args, err := star.SplitArgsExpand(line, func(k string) string { return s.env[k] })
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	var neg bool
+	if args[0] == "!" {
+		neg, args = true, args[1:]
+		if len(args) == 0 {
+			return fmt.Errorf("! must be followed by a command")
+		}
+	}
+	switch args[0] {
+	case "star":
+		var stdout, stderr bytes.Buffer
+		env := make(map[string]string, len(s.env))
+		for k, v := range s.env {
+			env[k] = v
+		}
+		err := star.Run(context.Background(), s.cmd, env, "star", args[1:], bytes.NewReader(s.stdin), &stdout, &stderr)
+		if err != nil {
+			// write the error the way star.Main would.
+			fmt.Fprintf(&stderr, "%v\n", err)
+		}
+		s.stdout, s.stderr, s.stdin = stdout.String(), stderr.String(), nil
+		switch {
+		case neg && err == nil:
+			return fmt.Errorf("command succeeded unexpectedly")
+		case !neg && err != nil:
+			return fmt.Errorf("command failed: %w", err)
+		}
+		return nil
+	case "stdout", "stderr":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s REGEXP", args[0])
+		}
+		re, err := regexp.Compile(`(?m)` + args[1])
+		if err != nil {
+			return err
+		}
+		out := s.stdout
+		if args[0] == "stderr" {
+			out = s.stderr
+		}
+		switch matched := re.MatchString(out); {
+		case neg && matched:
+			return fmt.Errorf("%s matches %q", args[0], args[1])
+		case !neg && !matched:
+			return fmt.Errorf("%s does not match %q", args[0], args[1])
+		}
+		return nil
+	case "cmp":
+		if neg || len(args) != 3 || (args[1] != "stdout" && args[1] != "stderr") {
+			return fmt.Errorf("usage: cmp stdout|stderr FILE")
+		}
+		expected, err := os.ReadFile(s.path(args[2]))
+		if err != nil {
+			return err
+		}
+		out := s.stdout
+		if args[1] == "stderr" {
+			out = s.stderr
+		}
+		if string(expected) != out {
+			return fmt.Errorf("%s does not match %s\n%s", args[1], args[2], diff(args[2], string(expected), out))
+		}
+		return nil
+	case "stdin":
+		if neg || len(args) != 2 {
+			return fmt.Errorf("usage: stdin FILE")
+		}
+		data, err := os.ReadFile(s.path(args[1]))
+		if err != nil {
+			return err
+		}
+		s.stdin = data
+		return nil
+	case "env":
+		if neg {
+			return fmt.Errorf("env cannot be negated")
+		}
+		for _, arg := range args[1:] {
+			k, v, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("usage: env KEY=VALUE...")
+			}
+			s.env[k] = v
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// path resolves p relative to the work directory.
+func (s *scriptState) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(s.work, p)
+}
+
+// txtar is an archive in the txtar format.
+// It is a comment, followed by files, each of which starts with a line of the form "-- name --".
+type txtar struct {
+	comment []byte
+	files   []txtarFile
+}
+
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+func parseTxtar(data []byte) txtar {
+	var ar txtar
+	cur := &ar.comment
+	for len(data) > 0 {
+		line := data
+		rest := []byte(nil)
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, rest = data[:i+1], data[i+1:]
+		}
+		data = rest
+		if name, ok := txtarMarker(line); ok {
+			ar.files = append(ar.files, txtarFile{name: name})
+			cur = &ar.files[len(ar.files)-1].data
+			continue
+		}
+		*cur = append(*cur, line...)
+	}
+	return ar
+}
+
+// txtarMarker returns the file name if line is a file marker.
+func txtarMarker(line []byte) (string, bool) {
+	x := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(x, "-- ") || !strings.HasSuffix(x, " --") || len(x) < 7 {
+		return "", false
+	}
+	name := strings.TrimSpace(x[3 : len(x)-3])
+	return name, name != ""
+}
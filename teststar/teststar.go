@@ -3,6 +3,7 @@ package teststar
 import (
 	"bytes"
 	"context"
+	"io"
 	"strings"
 	"testing"
 
@@ -11,28 +12,180 @@ import (
 	"go.brendoncarroll.net/star"
 )
 
-// OutIs runs the command with in as an input string, and checks that the output is out.
+// OutIsString runs the command with args, and checks that the output is expect.
 func OutIsString(t testing.TB, c *star.Command, args []string, expect string) {
 	t.Helper()
-	_, stdout := run(t, c, args)
-	require.Equal(t, expect, string(stdout))
+	New(t, *c).Args(args...).Run().Succeeds().StdoutIs(expect)
 }
 
+// OutContainsString runs the command with args, and checks that the output contains expect.
 func OutContainsString(t testing.TB, c *star.Command, args []string, expect string) {
 	t.Helper()
-	stdout, _ := run(t, c, args)
-	if !strings.Contains(string(stdout), expect) {
-		t.Log(string(stdout))
-		t.Fatalf("OUTPUT: ---\n%s\n---\ndoes not contain: ---\n%s\n", string(stdout), expect)
+	New(t, *c).Args(args...).Run().Succeeds().StdoutContains(expect)
+}
+
+// Invocation is a command, and everything needed to run it.
+// The methods which set up the Invocation modify it and return it, so they can be chained.
+type Invocation struct {
+	t        testing.TB
+	cmd      star.Command
+	ctx      context.Context
+	calledAs string
+	args     []string
+	env      map[string]string
+	stdin    io.Reader
+}
+
+// New returns an Invocation of cmd with no arguments, an empty environment, and empty input.
+func New(t testing.TB, cmd star.Command) *Invocation {
+	return &Invocation{
+		t:        t,
+		cmd:      cmd,
+		ctx:      context.Background(),
+		calledAs: t.Name(),
+		env:      map[string]string{},
+		stdin:    strings.NewReader(""),
+	}
+}
+
+// Args appends to the arguments passed to the command.
+func (inv *Invocation) Args(args ...string) *Invocation {
+	inv.args = append(inv.args, args...)
+	return inv
+}
+
+// Env sets an environment variable for the command.
+func (inv *Invocation) Env(key, value string) *Invocation {
+	inv.env[key] = value
+	return inv
+}
+
+// Stdin sets the input to the command.
+func (inv *Invocation) Stdin(in string) *Invocation {
+	inv.stdin = strings.NewReader(in)
+	return inv
+}
+
+// StdinReader sets the input to the command to be read from r.
+func (inv *Invocation) StdinReader(r io.Reader) *Invocation {
+	inv.stdin = r
+	return inv
+}
+
+// Context sets the context.Context passed to the command.
+func (inv *Invocation) Context(ctx context.Context) *Invocation {
+	inv.ctx = ctx
+	return inv
+}
+
+// CalledAs sets the name the command is called as.  It defaults to the name of the test.
+func (inv *Invocation) CalledAs(name string) *Invocation {
+	inv.calledAs = name
+	return inv
+}
+
+// Run runs the command and returns the Result.
+// Run does not fail the test if the command fails, use the methods on Result to check for that.
+func (inv *Invocation) Run() *Result {
+	var stdout, stderr bytes.Buffer
+	err := star.Run(inv.ctx, inv.cmd, inv.env, inv.calledAs, inv.args, inv.stdin, &stdout, &stderr)
+	return &Result{
+		t:        inv.t,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Err:      err,
+		ExitCode: star.ExitCode(err),
 	}
 }
 
-// run runs c with cmdStr
-func run(t testing.TB, c *star.Command, args []string) (stdout []byte, stderr []byte) {
-	ctx := context.Background()
-	var inbuf, outbuf, errbuf bytes.Buffer
-	env := map[string]string{}
-	err := star.Run(ctx, *c, env, t.Name(), args, &inbuf, &outbuf, &errbuf)
-	require.NoError(t, err)
-	return outbuf.Bytes(), errbuf.Bytes()
+// Result is the outcome of running an Invocation.
+// The methods on Result check something about it, fail the test if it is not true, and return the Result, so they can be chained.
+type Result struct {
+	t testing.TB
+
+	Stdout   string
+	Stderr   string
+	Err      error
+	ExitCode int
+}
+
+// Succeeds checks that the command returned no error.
+func (r *Result) Succeeds() *Result {
+	r.t.Helper()
+	require.NoError(r.t, r.Err, "STDERR: ---\n%s\n---", r.Stderr)
+	return r
+}
+
+// Fails checks that the command returned an error.
+func (r *Result) Fails() *Result {
+	r.t.Helper()
+	require.Error(r.t, r.Err, "STDOUT: ---\n%s\n---", r.Stdout)
+	return r
+}
+
+// ErrorContains checks that the command returned an error containing substr.
+func (r *Result) ErrorContains(substr string) *Result {
+	r.t.Helper()
+	require.ErrorContains(r.t, r.Err, substr)
+	return r
+}
+
+// UsageError checks that the command returned a star.UsageError of kind k.
+func (r *Result) UsageError(k star.UsageErrorKind) *Result {
+	r.t.Helper()
+	r.Fails()
+	if !star.IsUsageError(r.Err, k) {
+		r.t.Fatalf("expected usage error of kind %v, have %v", k, r.Err)
+	}
+	return r
+}
+
+// ExitCodeIs checks the exit code for the error returned by the command.
+func (r *Result) ExitCodeIs(code int) *Result {
+	r.t.Helper()
+	require.Equal(r.t, code, r.ExitCode, "error: %v", r.Err)
+	return r
+}
+
+// StdoutIs checks that the output of the command is exactly expect.
+func (r *Result) StdoutIs(expect string) *Result {
+	r.t.Helper()
+	require.Equal(r.t, expect, r.Stdout)
+	return r
+}
+
+// StdoutContains checks that the output of the command contains expect.
+func (r *Result) StdoutContains(expect string) *Result {
+	r.t.Helper()
+	containsString(r.t, "STDOUT", r.Stdout, expect)
+	return r
+}
+
+// StderrIs checks that the error output of the command is exactly expect.
+func (r *Result) StderrIs(expect string) *Result {
+	r.t.Helper()
+	require.Equal(r.t, expect, r.Stderr)
+	return r
+}
+
+// StderrContains checks that the error output of the command contains expect.
+func (r *Result) StderrContains(expect string) *Result {
+	r.t.Helper()
+	containsString(r.t, "STDERR", r.Stderr, expect)
+	return r
+}
+
+func containsString(t testing.TB, name, out, expect string) {
+	t.Helper()
+	if !strings.Contains(out, expect) {
+		t.Fatalf("%s: ---\n%s\n---\ndoes not contain: ---\n%s\n", name, out, expect)
+	}
+}
+
+// Lint fails the test if star.Lint finds any issues with cmd.
+func Lint(t testing.TB, cmd *star.Command) {
+	t.Helper()
+	for _, issue := range star.Lint(*cmd) {
+		t.Errorf("lint: %v", issue)
+	}
 }
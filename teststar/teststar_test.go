@@ -0,0 +1,104 @@
+package teststar
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.brendoncarroll.net/star"
+)
+
+func TestInvocation(t *testing.T) {
+	name := &star.Required[string]{PosName: "name", Parse: star.ParseString, Env: "NAME"}
+	greet := star.Command{
+		Pos: []star.Positional{name},
+		F: func(c star.Context) error {
+			c.Printf("hello %s\n", name.Load(c))
+			fmt.Fprintf(c.StdErr, "greeted\n")
+			return nil
+		},
+	}
+	cat := star.Command{
+		F: func(c star.Context) error {
+			_, err := io.Copy(c.StdOut, c.StdIn)
+			return err
+		},
+	}
+	fail := star.Command{
+		F: func(c star.Context) error {
+			return fmt.Errorf("failed")
+		},
+	}
+
+	OutIsString(t, &greet, []string{"world"}, "hello world\n")
+	New(t, greet).Args("world").Run().Succeeds().ExitCodeIs(0).StdoutIs("hello world\n").StderrIs("greeted\n")
+	New(t, greet).Env("NAME", "env").Run().Succeeds().StdoutContains("env")
+	New(t, greet).Run().UsageError(star.UsageMissing).ExitCodeIs(2).StderrContains("name")
+	New(t, cat).Stdin("abc").Run().Succeeds().StdoutIs("abc")
+	New(t, fail).Run().ErrorContains("failed").ExitCodeIs(1)
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	name := &star.Required[string]{PosName: "name", Parse: star.ParseString, ShortDoc: "who to greet"}
+	cmd := star.Command{
+		Metadata: star.Metadata{Short: "greets someone"},
+		Pos:      []star.Positional{name},
+		F: func(c star.Context) error {
+			c.Printf("hello %s at %s in %s\n", name.Load(c), time.Now().Format(time.RFC3339), dir)
+			return nil
+		},
+	}
+	New(t, cmd).Args("world").Run().Succeeds().
+		StdoutMatchesGolden("greet", NormalizeTimestamps, ReplaceString(dir, "$TMP"))
+	New(t, cmd).CalledAs("greet").Run().UsageError(star.UsageMissing).
+		StderrMatchesGolden("greet-usage")
+
+	assert.Equal(t, "--- a\n+++ actual\n@@ -1,2 +1,2 @@\n-hello\n+goodbye\n world\n", diff("a", "hello\nworld\n", "goodbye\nworld\n"))
+	assert.Equal(t, "--- a\n+++ actual\n@@ -1,2 +1,2 @@\n hello\n-world\n+world\n\\ No newline at end of file\n", diff("a", "hello\nworld\n", "hello\nworld"))
+}
+
+func TestScripts(t *testing.T) {
+	name := &star.Required[string]{PosName: "name", Parse: star.ParseString, Env: "NAME"}
+	file := &star.Required[string]{PosName: "file", Parse: star.ParseString}
+	root := star.NewDir(star.Metadata{}, map[string]star.Command{
+		"greet": {
+			Pos: []star.Positional{name},
+			F: func(c star.Context) error {
+				c.Printf("hello %s\n", name.Load(c))
+				return nil
+			},
+		},
+		"cat": {
+			Pos: []star.Positional{file},
+			F: func(c star.Context) error {
+				r := c.StdIn
+				if p := file.Load(c); p != "-" {
+					f, err := os.Open(p)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					r = f
+				}
+				_, err := io.Copy(c.StdOut, r)
+				return err
+			},
+		},
+	})
+	RunScripts(t, &root, filepath.Join("testdata", "scripts"))
+}
+
+func TestParseTxtar(t *testing.T) {
+	ar := parseTxtar([]byte("comment\n-- a.txt --\nA\n-- dir/b.txt --\nB\nB\n"))
+	assert.Equal(t, "comment\n", string(ar.comment))
+	assert.Equal(t, []txtarFile{
+		{name: "a.txt", data: []byte("A\n")},
+		{name: "dir/b.txt", data: []byte("B\nB\n")},
+	}, ar.files)
+}
@@ -0,0 +1,161 @@
+package star
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"text/tabwriter"
+
+	"golang.org/x/exp/maps"
+)
+
+// These variables override the version information read from the build info, if they are set.
+// They are meant to be set by the linker, e.g.
+//
+//	go build -ldflags "-X go.brendoncarroll.net/star.BuildVersion=v1.2.3"
+var (
+	BuildVersion  string
+	BuildRevision string
+	BuildTime     string
+)
+
+// VersionInfo describes the build of the running binary.
+type VersionInfo struct {
+	// Module is the path of the main module.
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Revision is the version control revision the binary was built from.
+	Revision string `json:"revision,omitempty"`
+	// Dirty is true if the working tree had uncommitted changes when the binary was built.
+	// It is nil if the build info does not say.
+	Dirty *bool `json:"dirty,omitempty"`
+	// Time is the time of the revision, or the value of BuildTime.
+	Time      string `json:"time,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+// ReadVersionInfo returns the VersionInfo for the running binary.
+// It is read from runtime/debug.ReadBuildInfo, and then BuildVersion, BuildRevision, and BuildTime are applied.
+func ReadVersionInfo() VersionInfo {
+	var ret VersionInfo
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		ret.Module = bi.Main.Path
+		ret.Version = bi.Main.Version
+		ret.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				ret.Revision = s.Value
+			case "vcs.time":
+				ret.Time = s.Value
+			case "vcs.modified":
+				ret.Dirty = Ptr(s.Value == "true")
+			}
+		}
+	}
+	if BuildVersion != "" {
+		ret.Version = BuildVersion
+	}
+	if BuildRevision != "" {
+		ret.Revision = BuildRevision
+	}
+	if BuildTime != "" {
+		ret.Time = BuildTime
+	}
+	return ret
+}
+
+// WriteTo writes the fields of the VersionInfo which are set to w, one per line.
+func (vi VersionInfo) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	var dirty string
+	if vi.Dirty != nil {
+		dirty = fmt.Sprint(*vi.Dirty)
+	}
+	for _, row := range [][2]string{
+		{"module", vi.Module},
+		{"version", vi.Version},
+		{"revision", vi.Revision},
+		{"dirty", dirty},
+		{"time", vi.Time},
+		{"go", vi.GoVersion},
+	} {
+		if row[1] == "" {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WithVersion returns a copy of dir with a "version" child, which writes the VersionInfo for the running binary.
+// The child takes --output to choose between text and json.
+func WithVersion(dir Command) Command {
+	output := &Optional[string]{
+		Choices:  Choices[string]{"text": "text", "json": "json"},
+		Default:  Ptr("text"),
+		ShortDoc: "the format to write the version information in",
+	}
+	dir.Children = maps.Clone(dir.Children)
+	dir.Children["version"] = Command{
+		Metadata: Metadata{Short: "prints version information"},
+		Flags:    map[string]Flag{"output": output},
+		F: func(c Context) error {
+			vi := ReadVersionInfo()
+			if out, _ := output.LoadOpt(c); out == "json" {
+				enc := json.NewEncoder(c.StdOut)
+				enc.SetIndent("", "  ")
+				return enc.Encode(vi)
+			}
+			_, err := vi.WriteTo(c.StdOut)
+			return err
+		},
+		helper: true,
+	}
+	return dir
+}
+
+// MainVersion returns a MainOption which adds a --version flag to the root command.
+// If it is passed, Main prints the VersionInfo and exits, instead of running the command.
+// The flag is parsed like any other flag of the root command, and is listed in its help.
+// If the root command already has a version flag, it is left alone.
+// Use WithVersion to add a version command with more options.
+func MainVersion() MainOption {
+	return func(cfg *mainConfig) {
+		cfg.Version = true
+	}
+}
+
+// withVersionFlag returns a copy of cmd with a --version flag, and true if the flag is passed in args.
+// cmd is returned unchanged if it already has a version flag.
+func withVersionFlag(cmd Command, args []string) (Command, bool) {
+	if _, exists := cmd.Flags["version"]; exists {
+		return cmd, false
+	}
+	version := &Boolean{ShortDoc: "prints version information and exits"}
+	flags := make(map[string]Flag, len(cmd.Flags)+1)
+	maps.Copy(flags, cmd.Flags)
+	flags["version"] = version
+	cmd.Flags = flags
+
+	vs := newValueSet(make(map[Parameter][]any), make(map[Parameter][]Source))
+	if _, err := parseFlags(vs, cmd.Flags, args); err != nil {
+		// let Run report the error.
+		return cmd, false
+	}
+	return cmd, len(vs.vals[version]) > 0
+}